@@ -0,0 +1,168 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// parseCIDR parses a network in CIDR notation, or a single address, which
+// stands for a network of just that address.
+func parseCIDR(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, &net.ParseError{Type: "IP address", Text: s}
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)}, nil
+	}
+	_, block, err := net.ParseCIDR(s)
+	return block, err
+}
+
+// cidrList collects repeated network flags.
+type cidrList []*net.IPNet
+
+func (l *cidrList) String() string {
+	var parts []string
+	for _, block := range *l {
+		parts = append(parts, block.String())
+	}
+	return strings.Join(parts, ",")
+}
+
+func (l *cidrList) Set(v string) error {
+	for _, s := range parsePrefixes(v) {
+		block, err := parseCIDR(s)
+		if err != nil {
+			return err
+		}
+		*l = append(*l, block)
+	}
+	return nil
+}
+
+// contains reports whether ip is in one of the networks. 0.0.0.0/0 and
+// ::/0 stand for all addresses, of either family.
+func (l cidrList) contains(ip net.IP) bool {
+	for _, block := range l {
+		if ones, _ := block.Mask.Size(); ones == 0 || block.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the peer of r.
+func clientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// denyAll stands for every address, the deny list when only allow is
+// given.
+func denyAll() cidrList {
+	_, all4, _ := net.ParseCIDR("0.0.0.0/0")
+	_, all6, _ := net.ParseCIDR("::/0")
+	return cidrList{all4, all6}
+}
+
+// accessHandler wraps h and rejects clients that are in deny but not in
+// allow. With only allow given, everybody else is denied.
+func accessHandler(allow, deny cidrList, h http.Handler) http.Handler {
+	if len(deny) == 0 {
+		deny = denyAll()
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := clientIP(r)
+		if ip == nil || (!allow.contains(ip) && deny.contains(ip)) {
+			logRequest(r, "%s denied access", r.RemoteAddr)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// privateHandler wraps h and rejects clients with public addresses.
+func privateHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ip := clientIP(r); ip == nil || !isPrivateIP(ip) {
+			logRequest(r, "%s denied access, not a private address", r.RemoteAddr)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// clientAllowed applies -allow, -deny and -private-only to a client of
+// sftp, ftp or tftp, as accessHandler and privateHandler do for http.
+func clientAllowed(addr net.Addr) bool {
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip = a.IP
+	case *net.UDPAddr:
+		ip = a.IP
+	}
+	if ip == nil {
+		return false
+	}
+	if len(allowIPs) > 0 || len(denyIPs) > 0 {
+		deny := denyIPs
+		if len(deny) == 0 {
+			deny = denyAll()
+		}
+		if !allowIPs.contains(ip) && deny.contains(ip) {
+			return false
+		}
+	}
+	return !*private || isPrivateIP(ip)
+}
+
+// defaultHosts returns the names of this machine that requests may use.
+func defaultHosts() []string {
+	hosts := []string{"localhost"}
+	if name, err := os.Hostname(); err == nil {
+		name = strings.ToLower(name)
+		hosts = append(hosts, name)
+		if !strings.Contains(name, ".") {
+			hosts = append(hosts, name+".local")
+		}
+	}
+	return hosts
+}
+
+// hostHandler wraps h and rejects requests for host names that are not in
+// hosts, which keeps web pages from reaching the share through a DNS name
+// they control that resolves to a local address. Addresses are always
+// accepted, since they cannot be rebound.
+func hostHandler(hosts []string, h http.Handler) http.Handler {
+	allowed := make(map[string]bool)
+	for _, host := range hosts {
+		allowed[strings.TrimSuffix(strings.ToLower(host), ".")] = true
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.TrimSuffix(strings.ToLower(host), ".")
+		// Link-local addresses come with a zone, escaped or not.
+		ip, _, _ := strings.Cut(host, "%")
+		if host != "" && !allowed[host] && net.ParseIP(ip) == nil {
+			logRequest(r, "%s denied access, unexpected host %q", r.RemoteAddr, r.Host)
+			http.Error(w, "unexpected host", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// accessLog writes requests to a file in the combined log format of
+// Apache and nginx, which log analyzers read.
+type accessLog struct {
+	fn string
+	// maxSize and every rotate the file once it grows beyond maxSize bytes
+	// or was written to for every, if not zero. maxFiles rotated files are
+	// kept as fn.1, fn.2 and so on, the newest first.
+	maxSize  int64
+	every    time.Duration
+	maxFiles int
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	opened time.Time
+}
+
+// openAccessLog opens fn for appending.
+func openAccessLog(fn string, maxSize int64, every time.Duration, maxFiles int) (*accessLog, error) {
+	l := &accessLog{fn: fn, maxSize: maxSize, every: every, maxFiles: maxFiles}
+	if err := l.reopen(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// reopen opens the file again, after it was moved away by log rotation.
+func (l *accessLog) reopen() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.open()
+}
+
+func (l *accessLog) open() error {
+	f, err := os.OpenFile(l.fn, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if l.f != nil {
+		l.f.Close()
+	}
+	l.f, l.size, l.opened = f, fi.Size(), time.Now()
+	return nil
+}
+
+// rotate moves the file to fn.1, the older ones one further, and starts a
+// new one.
+func (l *accessLog) rotate() error {
+	os.Remove(fmt.Sprintf("%s.%d", l.fn, l.maxFiles))
+	for i := l.maxFiles - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", l.fn, i), fmt.Sprintf("%s.%d", l.fn, i+1))
+	}
+	if l.maxFiles > 0 {
+		if err := os.Rename(l.fn, l.fn+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(l.fn); err != nil {
+		return err
+	}
+	return l.open()
+}
+
+func (l *accessLog) write(line string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if (l.maxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.maxSize) || (l.every > 0 && time.Since(l.opened) >= l.every) {
+		if err := l.rotate(); err != nil {
+			// Retrying on every request only fills the log.
+			log.Printf("rotating %s: %v, no longer rotating", l.fn, err)
+			l.maxSize, l.every = 0, 0
+		}
+	}
+	n, _ := l.f.WriteString(line)
+	l.size += int64(n)
+}
+
+// Handler wraps h and logs each request once it is done.
+func (l *accessLog) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &responseRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r)
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		user := "-"
+		if name, _, ok := r.BasicAuth(); ok && name != "" {
+			user = name
+		}
+		size := "-"
+		if rec.written > 0 {
+			size = strconv.FormatInt(rec.written, 10)
+		}
+		line := fmt.Sprintf("%s - %s [%s] %q %d %s %q %q\n",
+			host, user, start.Format("02/Jan/2006:15:04:05 -0700"),
+			r.Method+" "+r.RequestURI+" "+r.Proto, rec.Status(), size,
+			orDash(r.Referer()), orDash(r.UserAgent()))
+		l.write(line)
+	})
+}
+
+// orDash returns s, or - for an empty value like the combined format.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// newACMEManager returns a manager that gets certificates for domains from
+// Let's Encrypt, through TLS-ALPN-01 on the https port or HTTP-01 via its
+// HTTPHandler. Certificates and the account key are kept in the cache
+// directory.
+func newACMEManager(domains []string, email, cache string) *autocert.Manager {
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Cache:      autocert.DirCache(cache),
+		Email:      email,
+	}
+}
+
+// acmeTLSConfig is the TLS configuration of m, with the client certificate
+// settings of base, if any.
+func acmeTLSConfig(m *autocert.Manager, base *tls.Config) *tls.Config {
+	c := m.TLSConfig()
+	if base != nil {
+		c.ClientCAs, c.ClientAuth = base.ClientCAs, base.ClientAuth
+	}
+	return c
+}
+
+// flagSet reports whether the flag name was given on the command line.
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		set = set || f.Name == name
+	})
+	return set
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// adminPath is where -admin serves the api for webshare ctl.
+const adminPath = "/_/admin"
+
+// shareAdmin lets webshare ctl change a running share, for requests with
+// the token as bearer token.
+type shareAdmin struct {
+	token string
+	// path is the path of the share link below the token prefix.
+	path string
+	// deadline is set with -t.
+	deadline *deadline
+	// uploadable is set if uploads can be turned on.
+	uploadable bool
+	shutdown   func()
+
+	mu sync.Mutex
+	// link is the printed link with a qr code, empty once it no longer
+	// works after rotating the token, like a short link.
+	link string
+}
+
+// adminStatus is the answer of the api.
+type adminStatus struct {
+	Message    string     `json:"message,omitempty"`
+	Path       string     `json:"path"`
+	Link       string     `json:"link,omitempty"`
+	Uploads    bool       `json:"uploads"`
+	ShutdownAt *time.Time `json:"shutdown_at,omitempty"`
+}
+
+func (a *shareAdmin) status(msg string) adminStatus {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s := adminStatus{
+		Message: msg,
+		Path:    tokenPrefix.get() + a.path,
+		Link:    a.link,
+		Uploads: a.uploadable && !uploadsOff.Load(),
+	}
+	if a.deadline != nil {
+		at := a.deadline.get()
+		s.ShutdownAt = &at
+	}
+	return s
+}
+
+// rotateToken replaces the token prefix, which cuts off everyone using the
+// old links.
+func (a *shareAdmin) rotateToken() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	old, next := tokenPrefix.get(), "/s/"+randomToken(10)
+	tokenPrefix.set(next)
+	if strings.Contains(a.link, old) {
+		a.link = strings.Replace(a.link, old, next, 1)
+	} else {
+		a.link = ""
+	}
+}
+
+// Handler wraps h and answers GET /_/admin with the status of the share and
+// POSTs to /_/admin/extend?by=30m, /_/admin/rotate-token,
+// /_/admin/uploads?on=true and /_/admin/shutdown.
+func (a *shareAdmin) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		action, ok := strings.CutPrefix(r.URL.Path, adminPath)
+		if !ok || (action != "" && !strings.HasPrefix(action, "/")) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if !bearerOK(r, a.token) {
+			logRequest(r, "%s admin token rejected", r.RemoteAddr)
+			emitAuthFailure(r, "admin token rejected")
+			w.Header().Set("WWW-Authenticate", `Bearer realm="webshare"`)
+			http.Error(w, "invalid admin token", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		if action != "" && r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var msg string
+		switch action {
+		case "":
+		case "/extend":
+			by, err := time.ParseDuration(r.FormValue("by"))
+			if err != nil || by <= 0 {
+				http.Error(w, "want a duration like 30m in by", http.StatusBadRequest)
+				return
+			}
+			if a.deadline == nil {
+				http.Error(w, "the share has no timeout, see -t", http.StatusConflict)
+				return
+			}
+			at := a.deadline.extend(by)
+			msg = "shutting down at " + at.Format(time.DateTime)
+		case "/rotate-token":
+			if tokenPrefix.get() == "" {
+				http.Error(w, "the share has no token, see -token", http.StatusConflict)
+				return
+			}
+			a.rotateToken()
+			msg = "rotated the token, the old links no longer work"
+		case "/uploads":
+			on, err := strconv.ParseBool(r.FormValue("on"))
+			if err != nil {
+				http.Error(w, "want true or false in on", http.StatusBadRequest)
+				return
+			}
+			if on && !a.uploadable {
+				http.Error(w, "uploads only work when sharing directories, in the -sandbox only if started with -upload", http.StatusConflict)
+				return
+			}
+			uploadsOff.Store(!on)
+			msg = "uploads turned off"
+			if on {
+				msg = "uploads turned on"
+			}
+		case "/shutdown":
+			msg = "shutting down"
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		if msg != "" {
+			logRequest(r, "admin: %s", msg)
+		}
+		writeJSON(w, a.status(msg))
+		if action == "/shutdown" {
+			a.shutdown()
+		}
+	})
+}
+
+// bearerOK reports whether r carries token as bearer token.
+func bearerOK(r *http.Request, token string) bool {
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) == 1
+}
+
+// deadline runs a function at a time that can be pushed back, for -t.
+type deadline struct {
+	mu    sync.Mutex
+	timer *time.Timer
+	at    time.Time
+}
+
+func newDeadline(d time.Duration, f func()) *deadline {
+	return &deadline{timer: time.AfterFunc(d, f), at: time.Now().Add(d)}
+}
+
+func (d *deadline) get() time.Time {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.at
+}
+
+// extend pushes the deadline back by, counting from now if it passed, and
+// returns the new one.
+func (d *deadline) extend(by time.Duration) time.Time {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.at = later(d.at, time.Now()).Add(by)
+	d.timer.Reset(time.Until(d.at))
+	return d.at
+}
+
+func later(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// adminAccess is saved by -admin for webshare ctl on the same machine.
+type adminAccess struct {
+	URL   string `json:"url"`
+	Token string `json:"token"`
+}
+
+// adminURL returns the admin api on the first of eps, preferring loopback
+// addresses.
+func adminURL(scheme string, eps []endpoint) string {
+	if len(eps) == 0 {
+		return ""
+	}
+	e := eps[0]
+	for _, ep := range eps {
+		if ep.ip != nil && ep.ip.IsLoopback() {
+			e = ep
+			break
+		}
+	}
+	return (&url.URL{Scheme: scheme, Host: net.JoinHostPort(e.host, strconv.Itoa(e.port)), Path: adminPath}).String()
+}
+
+// writeAdminAccess saves a to the user config directory and returns the
+// file name.
+func writeAdminAccess(a adminAccess) (string, error) {
+	fn, err := configFile("admin.json")
+	if err != nil {
+		return "", err
+	}
+	b, err := json.MarshalIndent(a, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(fn), 0700); err != nil {
+		return "", err
+	}
+	return fn, os.WriteFile(fn, append(b, '\n'), 0600)
+}
+
+// runCtl runs webshare ctl, which changes a share started with -admin.
+func runCtl(args []string) {
+	fs := flag.NewFlagSet("ctl", flag.ExitOnError)
+	apiURL := fs.String("url", "", "admin api of the share, e.g. http://192.168.1.10:3000/_/admin (default the one of the -admin share on this machine)")
+	token := fs.String("token", "", "admin token of the share (default the one of the -admin share on this machine)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: webshare ctl [flags] status | extend duration | rotate-token | uploads on|off | shutdown\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *apiURL == "" || *token == "" {
+		fn, err := configFile("admin.json")
+		if err != nil {
+			log.Fatal(err)
+		}
+		b, err := os.ReadFile(fn)
+		if errors.Is(err, os.ErrNotExist) {
+			log.Fatal("no share with -admin is running here, see -url and -token")
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		var a adminAccess
+		if err := json.Unmarshal(b, &a); err != nil {
+			log.Fatalf("%s: %v", fn, err)
+		}
+		if *apiURL == "" {
+			*apiURL = a.URL
+		}
+		if *token == "" {
+			*token = a.Token
+		}
+	}
+	method, action, form := http.MethodPost, "", url.Values{}
+	switch cmd := fs.Arg(0); {
+	case cmd == "status" && fs.NArg() == 1:
+		method = http.MethodGet
+	case cmd == "extend" && fs.NArg() == 2:
+		action = "/extend"
+		form.Set("by", fs.Arg(1))
+	case cmd == "rotate-token" && fs.NArg() == 1:
+		action = "/rotate-token"
+	case cmd == "uploads" && fs.NArg() == 2 && (fs.Arg(1) == "on" || fs.Arg(1) == "off"):
+		action = "/uploads"
+		form.Set("on", strconv.FormatBool(fs.Arg(1) == "on"))
+	case cmd == "shutdown" && fs.NArg() == 1:
+		action = "/shutdown"
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(*apiURL, "/")+action, strings.NewReader(form.Encode()))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+*token)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	client := &http.Client{Timeout: 10 * time.Second}
+	// The self-signed certificate of -tls cannot be verified, which does
+	// not matter on this machine.
+	if ip := net.ParseIP(req.URL.Hostname()); ip != nil && ip.IsLoopback() {
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		log.Fatalf("%s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+	var s adminStatus
+	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+		log.Fatal(err)
+	}
+	if s.Message != "" {
+		fmt.Println(s.Message)
+	}
+	if s.Link != "" {
+		fmt.Printf("link:     %s\n", s.Link)
+	} else {
+		fmt.Printf("path:     %s\n", s.Path)
+	}
+	uploads := "off"
+	if s.Uploads {
+		uploads = "on"
+	}
+	fmt.Printf("uploads:  %s\n", uploads)
+	if s.ShutdownAt != nil {
+		fmt.Printf("shutdown: %s, in %s\n", s.ShutdownAt.Local().Format(time.DateTime), time.Until(*s.ShutdownAt).Round(time.Second))
+	}
+}
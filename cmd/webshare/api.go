@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// apiEntry is a file or directory as returned by the json api.
+type apiEntry struct {
+	Name     string      `json:"name"`
+	Path     string      `json:"path"`
+	URL      string      `json:"url"`
+	Dir      bool        `json:"dir"`
+	Size     int64       `json:"size"`
+	ModTime  time.Time   `json:"mtime"`
+	Mode     string      `json:"mode"`
+	Children []*apiEntry `json:"children,omitempty"`
+}
+
+// newAPIEntry returns the entry of p, with the link to it below prefix,
+// the path the share is mounted at.
+func newAPIEntry(prefix, p string, fi os.FileInfo) *apiEntry {
+	u := url.URL{Path: prefix + p}
+	if fi.IsDir() && p != "/" {
+		u.Path += "/"
+	}
+	return &apiEntry{
+		Name:    fi.Name(),
+		Path:    p,
+		URL:     u.String(),
+		Dir:     fi.IsDir(),
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+		Mode:    fi.Mode().String(),
+	}
+}
+
+// listTree fills in the children of the directory entry e, descending into
+// subdirectories if recursive is set.
+func listTree(dir, prefix string, e *apiEntry, recursive bool) error {
+	entries, err := os.ReadDir(localPath(dir, e.Path))
+	if err != nil {
+		return err
+	}
+	e.Children = []*apiEntry{}
+	for _, de := range entries {
+		if !policy.listedEntry(dir, path.Join(e.Path, de.Name()), de) {
+			continue
+		}
+		fi, err := de.Info()
+		if err != nil {
+			continue
+		}
+		c := newAPIEntry(prefix, path.Join(e.Path, de.Name()), fi)
+		if recursive && c.Dir {
+			if err := listTree(dir, prefix, c, true); err != nil {
+				log.Printf("api ls %s: %v", c.Path, err)
+			}
+		}
+		e.Children = append(e.Children, c)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		log.Printf("api: %v", err)
+	}
+}
+
+// apiListHandler answers /api/ls?path=...&recursive=1 with the entries of
+// a directory, or the whole tree below it.
+func apiListHandler(dir string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		p := path.Clean("/" + query.Get("path"))
+		fi, err := policy.stat(dir, p)
+		if err != nil {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		e := newAPIEntry(mountPrefix(r), p, fi)
+		if e.Dir {
+			recursive := query.Get("recursive")
+			if err := listTree(dir, mountPrefix(r), e, recursive == "1" || recursive == "true"); err != nil {
+				http.Error(w, "cannot read directory", http.StatusInternalServerError)
+				return
+			}
+		}
+		writeJSON(w, e)
+	})
+}
+
+// apiStat is the metadata returned by /api/stat.
+type apiStat struct {
+	*apiEntry
+	MimeType string `json:"mime_type,omitempty"`
+	SHA256   string `json:"sha256,omitempty"`
+}
+
+// contentType guesses the mime type of fn by its extension, falling back to
+// sniffing its first bytes like http.FileServer does.
+func contentType(fn string) string {
+	if ctype := mime.TypeByExtension(filepath.Ext(fn)); ctype != "" {
+		return ctype
+	}
+	f, err := os.Open(fn)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	buf := make([]byte, 512)
+	n, _ := io.ReadFull(f, buf)
+	return http.DetectContentType(buf[:n])
+}
+
+// apiStatHandler answers /api/stat/<path> with the metadata of a file. The
+// sha256 sum is only included with ?sha256=1, since it has to read the file.
+func apiStatHandler(dir string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := path.Clean("/" + strings.TrimPrefix(r.URL.Path, "/api/stat"))
+		fn := localPath(dir, p)
+		fi, err := policy.stat(dir, p)
+		if err != nil {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		st := apiStat{apiEntry: newAPIEntry(mountPrefix(r), p, fi)}
+		if !fi.IsDir() {
+			st.MimeType = contentType(fn)
+			if v := r.URL.Query().Get("sha256"); v == "1" || v == "true" {
+				if st.SHA256, err = checksums.sha256(fn); err != nil {
+					logRequest(r, "api stat %s: %v", p, err)
+					http.Error(w, "cannot read file", http.StatusInternalServerError)
+					return
+				}
+			}
+		}
+		writeJSON(w, st)
+	})
+}
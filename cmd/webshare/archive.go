@@ -0,0 +1,183 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// archiveHandler wraps h and streams the requested directory as an archive
+// if the format query parameter asks for one. Nothing is written to disk.
+func archiveHandler(dir string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		format := r.URL.Query().Get("format")
+		if format == "" || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		fn := localPath(dir, r.URL.Path)
+		fi, err := os.Stat(fn)
+		if err != nil || !fi.IsDir() {
+			h.ServeHTTP(w, r)
+			return
+		}
+		name := filepath.Base(fn)
+		if abs, err := filepath.Abs(fn); err == nil {
+			name = filepath.Base(abs)
+		}
+		var write func(io.Writer, string, string) error
+		switch format {
+		case "zip":
+			name += ".zip"
+			w.Header().Set("Content-Type", "application/zip")
+			write = writeZip
+		case "tar.gz", "tgz":
+			name += ".tar.gz"
+			w.Header().Set("Content-Type", "application/gzip")
+			write = writeTarGz
+		default:
+			http.Error(w, fmt.Sprintf("unsupported archive format: %s", format), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+		if r.Method == http.MethodHead {
+			return
+		}
+		if err := write(w, dir, r.URL.Path); err != nil {
+			// Headers are already sent, all we can do is log and cut the stream.
+			logRequest(r, "archive %s: %v", fn, err)
+		}
+	})
+}
+
+// writeZip writes all regular files below base in the shared directory dir
+// as a zip archive to w.
+func writeZip(w io.Writer, dir, base string) error {
+	root := localPath(dir, base)
+	zw := zip.NewWriter(w)
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == root {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		if !policy.listedEntry(dir, path.Join(base, filepath.ToSlash(rel)), d) {
+			return skipEntry(d)
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			fh, err := zip.FileInfoHeader(fi)
+			if err != nil {
+				return err
+			}
+			fh.Name = filepath.ToSlash(rel) + "/"
+			_, err = zw.CreateHeader(fh)
+			return err
+		case fi.Mode().IsRegular():
+			fh, err := zip.FileInfoHeader(fi)
+			if err != nil {
+				return err
+			}
+			fh.Name = filepath.ToSlash(rel)
+			fh.Method = zip.Deflate
+			fw, err := zw.CreateHeader(fh)
+			if err != nil {
+				return err
+			}
+			f, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			_, err = io.Copy(fw, f)
+			return err
+		default:
+			return nil
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// writeTarGz writes the tree below base in the shared directory dir as a
+// gzip compressed tarball to w, keeping file modes and storing symlinks as
+// links, except those the policy does not follow.
+func writeTarGz(w io.Writer, dir, base string) error {
+	root := localPath(dir, base)
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == root {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		if !policy.listedEntry(dir, path.Join(base, filepath.ToSlash(rel)), d) {
+			return skipEntry(d)
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		var link string
+		if fi.Mode()&fs.ModeSymlink != 0 {
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		} else if !fi.Mode().IsRegular() && !fi.IsDir() {
+			return nil
+		}
+		hdr, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if fi.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// archiveEntry is a file or directory inside an archive.
+type archiveEntry struct {
+	info     fs.FileInfo
+	children []string
+	// data is set if the member is stored uncompressed and can be read at
+	// random, which allows range requests. Otherwise open streams it.
+	data io.ReaderAt
+	open func() (io.ReadCloser, error)
+}
+
+// archiveServer presents the members of a zip or tar archive as a read-only
+// directory tree. Members are read from the archive on demand.
+type archiveServer struct {
+	file    *os.File
+	entries map[string]*archiveEntry
+}
+
+// dirInfo describes a directory that is implied by member paths but has no
+// entry of its own in the archive.
+type dirInfo struct {
+	name    string
+	modTime time.Time
+}
+
+func (d dirInfo) Name() string       { return d.name }
+func (d dirInfo) Size() int64        { return 0 }
+func (d dirInfo) Mode() fs.FileMode  { return fs.ModeDir | 0755 }
+func (d dirInfo) ModTime() time.Time { return d.modTime }
+func (d dirInfo) IsDir() bool        { return true }
+func (d dirInfo) Sys() any           { return nil }
+
+// countingReader keeps track of the offset into the underlying reader.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// openArchive indexes the zip, tar or tar.gz archive at fn, detected by its
+// contents rather than its extension.
+func openArchive(fn string) (*archiveServer, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	s := &archiveServer{
+		file:    f,
+		entries: map[string]*archiveEntry{"/": {info: dirInfo{name: "/", modTime: fi.ModTime()}}},
+	}
+	magic := make([]byte, 4)
+	n, _ := f.ReadAt(magic, 0)
+	switch {
+	case bytes.HasPrefix(magic[:n], []byte("PK\x03\x04")), bytes.HasPrefix(magic[:n], []byte("PK\x05\x06")):
+		err = s.indexZip(fi.Size())
+	case bytes.HasPrefix(magic[:n], []byte{0x1f, 0x8b}):
+		err = s.indexTar(true)
+	default:
+		err = s.indexTar(false)
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+	for _, e := range s.entries {
+		sort.Strings(e.children)
+	}
+	return s, nil
+}
+
+// Close closes the underlying archive file.
+func (s *archiveServer) Close() error {
+	return s.file.Close()
+}
+
+// add registers a member, creating entries for missing parent directories.
+func (s *archiveServer) add(name string, e *archiveEntry) {
+	p := path.Clean("/" + name)
+	if p == "/" {
+		return
+	}
+	if existing, ok := s.entries[p]; ok {
+		// An explicit directory entry replaces an implied one.
+		e.children = existing.children
+		s.entries[p] = e
+		return
+	}
+	s.entries[p] = e
+	for {
+		parent := path.Dir(p)
+		pe, ok := s.entries[parent]
+		if !ok {
+			pe = &archiveEntry{info: dirInfo{name: path.Base(parent), modTime: e.info.ModTime()}}
+			s.entries[parent] = pe
+		}
+		pe.children = append(pe.children, path.Base(p))
+		if ok {
+			return
+		}
+		p = parent
+	}
+}
+
+func (s *archiveServer) indexZip(size int64) error {
+	zr, err := zip.NewReader(s.file, size)
+	if err != nil {
+		return err
+	}
+	for _, zf := range zr.File {
+		info := zf.FileInfo()
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			continue
+		}
+		e := &archiveEntry{info: info, open: zf.Open}
+		if zf.Method == zip.Store && !info.IsDir() {
+			if off, err := zf.DataOffset(); err == nil {
+				e.data = io.NewSectionReader(s.file, off, int64(zf.UncompressedSize64))
+			}
+		}
+		s.add(zf.Name, e)
+	}
+	return nil
+}
+
+func (s *archiveServer) indexTar(gzipped bool) error {
+	cr := &countingReader{r: io.NewSectionReader(s.file, 0, 1<<63-1)}
+	var r io.Reader = cr
+	if gzipped {
+		zr, err := gzip.NewReader(bufio.NewReader(cr))
+		if err != nil {
+			return err
+		}
+		r = zr
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		info := hdr.FileInfo()
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			continue
+		}
+		e := &archiveEntry{info: info}
+		if !info.IsDir() {
+			if gzipped {
+				e.open = s.tarMemberOpener(hdr.Name)
+			} else {
+				// The tar reader does not read ahead, so the data of this
+				// member starts right where the reader stopped.
+				e.data = io.NewSectionReader(s.file, cr.n, hdr.Size)
+			}
+		}
+		s.add(hdr.Name, e)
+	}
+}
+
+// tarMemberOpener returns a function that decompresses the archive from the
+// start up to the member called name.
+func (s *archiveServer) tarMemberOpener(name string) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		zr, err := gzip.NewReader(bufio.NewReader(io.NewSectionReader(s.file, 0, 1<<63-1)))
+		if err != nil {
+			return nil, err
+		}
+		tr := tar.NewReader(zr)
+		for {
+			hdr, err := tr.Next()
+			if err != nil {
+				zr.Close()
+				if err == io.EOF {
+					return nil, fs.ErrNotExist
+				}
+				return nil, err
+			}
+			if hdr.Name == name {
+				return struct {
+					io.Reader
+					io.Closer
+				}{tr, zr}, nil
+			}
+		}
+	}
+}
+
+// stat returns the info of the member at name, if the policy allows it.
+func (s *archiveServer) stat(name string) (fs.FileInfo, error) {
+	p := path.Clean("/" + name)
+	e, ok := s.entries[p]
+	if !ok || !policy.allowed(p, e.info.IsDir()) {
+		return nil, fs.ErrNotExist
+	}
+	return e.info, nil
+}
+
+func (s *archiveServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	p := path.Clean("/" + r.URL.Path)
+	e, ok := s.entries[p]
+	if !ok || !policy.allowed(p, e.info.IsDir()) {
+		http.NotFound(w, r)
+		return
+	}
+	if e.info.IsDir() {
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			http.Redirect(w, r, path.Base(p)+"/", http.StatusMovedPermanently)
+			return
+		}
+		var infos []fs.FileInfo
+		for _, name := range e.children {
+			if c, ok := s.entries[path.Join(p, name)]; ok && policy.listed(path.Join(p, name), c.info.IsDir()) {
+				infos = append(infos, c.info)
+			}
+		}
+		renderListing(w, listingData{Path: r.URL.Path}, infos)
+		return
+	}
+	if e.data != nil {
+		http.ServeContent(w, r, e.info.Name(), e.info.ModTime(), io.NewSectionReader(e.data, 0, e.info.Size()))
+		return
+	}
+	rc, err := e.open()
+	if err != nil {
+		logRequest(r, "archive member %s: %v", p, err)
+		if errors.Is(err, fs.ErrNotExist) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "cannot read archive member", http.StatusInternalServerError)
+		return
+	}
+	defer rc.Close()
+	ctype := mime.TypeByExtension(path.Ext(p))
+	if ctype == "" {
+		ctype = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("Content-Length", strconv.FormatInt(e.info.Size(), 10))
+	w.Header().Set("Last-Modified", e.info.ModTime().UTC().Format(http.TimeFormat))
+	if r.Method == http.MethodHead {
+		return
+	}
+	io.Copy(w, rc)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// attachHandler wraps h and marks successful file responses as attachments,
+// so browsers save them instead of rendering them. With all set this applies
+// to every file, otherwise only to requests with dl=1.
+func attachHandler(all bool, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if (!all && query.Get("dl") != "1") || strings.HasSuffix(r.URL.Path, "/") || query.Has("view") {
+			h.ServeHTTP(w, r)
+			return
+		}
+		disposition := mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(r.URL.Path)})
+		h.ServeHTTP(&attachWriter{ResponseWriter: w, disposition: disposition}, r)
+	})
+}
+
+// attachWriter sets the Content-Disposition header, unless the response is
+// an error or a redirect, or the handler chose a disposition itself.
+type attachWriter struct {
+	http.ResponseWriter
+	disposition string
+	wroteHeader bool
+}
+
+func (aw *attachWriter) WriteHeader(code int) {
+	if !aw.wroteHeader {
+		aw.wroteHeader = true
+		if code < 300 && aw.Header().Get("Content-Disposition") == "" {
+			aw.Header().Set("Content-Disposition", aw.disposition)
+		}
+	}
+	aw.ResponseWriter.WriteHeader(code)
+}
+
+func (aw *attachWriter) Write(b []byte) (int, error) {
+	if !aw.wroteHeader {
+		aw.WriteHeader(http.StatusOK)
+	}
+	return aw.ResponseWriter.Write(b)
+}
+
+func (aw *attachWriter) ReadFrom(r io.Reader) (int64, error) {
+	if !aw.wroteHeader {
+		aw.WriteHeader(http.StatusOK)
+	}
+	if rf, ok := aw.ResponseWriter.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(aw.ResponseWriter, r)
+}
+
+func (aw *attachWriter) Flush() {
+	if f, ok := aw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (aw *attachWriter) Unwrap() http.ResponseWriter {
+	return aw.ResponseWriter
+}
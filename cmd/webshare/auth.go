@@ -0,0 +1,47 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// tokenPrefix is the secret path prefix required on all requests with
+// -token. It is stripped before requests reach the handlers.
+var tokenPrefix secretPrefix
+
+// secretPrefix holds the token prefix, which webshare ctl can rotate while
+// the share runs.
+type secretPrefix struct {
+	mu     sync.RWMutex
+	prefix string
+}
+
+func (s *secretPrefix) get() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.prefix
+}
+
+func (s *secretPrefix) set(prefix string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prefix = prefix
+}
+
+// Handler wraps h like mountHandler with the current prefix, so links with
+// an old one stop working once it is rotated.
+func (s *secretPrefix) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mountHandler(s.get(), h).ServeHTTP(w, r)
+	})
+}
+
+// randomToken returns an unguessable lowercase string of n random bytes.
+func randomToken(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// checksumKey identifies a version of a file; a changed size or mtime
+// invalidates the cached checksum.
+type checksumKey struct {
+	path  string
+	size  int64
+	mtime int64
+}
+
+// checksumCache computes sha256 sums of files lazily and remembers them.
+type checksumCache struct {
+	mu   sync.Mutex
+	sums map[checksumKey]string
+}
+
+var checksums = &checksumCache{sums: make(map[checksumKey]string)}
+
+// sha256 returns the hex encoded sha256 sum of the file fn.
+func (c *checksumCache) sha256(fn string) (string, error) {
+	abs, err := filepath.Abs(fn)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(abs)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	key := checksumKey{path: abs, size: fi.Size(), mtime: fi.ModTime().UnixNano()}
+	c.mu.Lock()
+	sum, ok := c.sums[key]
+	c.mu.Unlock()
+	if ok {
+		return sum, nil
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	sum = hex.EncodeToString(h.Sum(nil))
+	c.mu.Lock()
+	c.sums[key] = sum
+	c.mu.Unlock()
+	return sum, nil
+}
+
+// checksumHandler wraps h and answers ?checksum=sha256 on files with a
+// sidecar in the format of sha256sum.
+func checksumHandler(dir string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		algo := r.URL.Query().Get("checksum")
+		if algo == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if algo != "sha256" {
+			http.Error(w, "unsupported checksum: "+algo, http.StatusBadRequest)
+			return
+		}
+		fn := localPath(dir, r.URL.Path)
+		if fi, err := os.Stat(fn); err != nil || !fi.Mode().IsRegular() {
+			http.NotFound(w, r)
+			return
+		}
+		sum, err := checksums.sha256(fn)
+		if err != nil {
+			logRequest(r, "checksum %s: %v", fn, err)
+			http.Error(w, "cannot read file", http.StatusInternalServerError)
+			return
+		}
+		name := filepath.Base(fn)
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": name + ".sha256"}))
+		fmt.Fprintf(w, "%s  %s\n", sum, name)
+	})
+}
+
+// sumsHandler answers /SHA256SUMS with the checksums of all files in dir,
+// usable with sha256sum -c from within the shared directory. Lines are
+// written as the sums are computed.
+func sumsHandler(dir string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if r.Method == http.MethodHead {
+			return
+		}
+		flusher, _ := w.(http.Flusher)
+		err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			rel, err := filepath.Rel(dir, p)
+			if err != nil {
+				return nil
+			}
+			if p != dir && !policy.listed(filepath.ToSlash(rel), d.IsDir()) {
+				return skipEntry(d)
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			if r.Context().Err() != nil {
+				return r.Context().Err()
+			}
+			sum, err := checksums.sha256(p)
+			if err != nil {
+				logRequest(r, "checksum %s: %v", p, err)
+				return nil
+			}
+			fmt.Fprintf(w, "%s  %s\n", sum, filepath.ToSlash(rel))
+			if flusher != nil {
+				flusher.Flush()
+			}
+			return nil
+		})
+		if err != nil {
+			logRequest(r, "SHA256SUMS: %v", err)
+		}
+	})
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/mdp/qrterminal"
+)
+
+// serveCommands share something and take the flags of webshare, the rest
+// of the commands have flags of their own.
+var serveCommands = map[string]bool{"serve": true, "send": true, "receive": true}
+
+// splitCommand returns the subcommand in args and its arguments, serve if
+// args start with a flag, as webshare did before it had subcommands.
+func splitCommand(args []string) (string, []string) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return "serve", args
+	}
+	return args[0], args[1:]
+}
+
+// usage lists the subcommands and the flags of webshare.
+func usage() {
+	w := flag.CommandLine.Output()
+	fmt.Fprintf(w, "usage: webshare [command] [flags] [arguments]\n\ncommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-11s %s\n", c.name, c.usage)
+	}
+	fmt.Fprintf(w, "\nwithout a command, webshare serves the current directory\n\nflags of serve, send, receive and service install:\n")
+	flag.PrintDefaults()
+}
+
+// parseServeFlags parses the flags of serve, send and receive, which may
+// also follow the arguments, and returns the arguments.
+func parseServeFlags(command string, args []string) []string {
+	flag.CommandLine.Init("webshare "+command, flag.ExitOnError)
+	var rest []string
+	for {
+		flag.CommandLine.Parse(args)
+		if flag.NArg() == 0 {
+			return rest
+		}
+		rest, args = append(rest, flag.Arg(0)), flag.Args()[1:]
+	}
+}
+
+// setupCommand applies the arguments of serve, send and receive: serve
+// takes directories like -d, send a file to share until it was downloaded
+// once and receive the directory to save uploads to, the current one by
+// default. It returns the file to send.
+func setupCommand(command string, args []string) string {
+	fail := func(format string, v ...any) {
+		fmt.Fprintf(os.Stderr, format+"\n", v...)
+		os.Exit(2)
+	}
+	if command != "serve" {
+		for _, name := range []string{"d", "route", "routes", "vhost", "stdin", "archive", "proxy"} {
+			if cmdlineFlags[name] {
+				fail("-%s does not work with webshare %s", name, command)
+			}
+		}
+	}
+	switch command {
+	case "serve":
+		for _, dir := range args {
+			if err := flag.Set("d", dir); err != nil {
+				fail("%s: %v", dir, err)
+			}
+		}
+	case "send":
+		if len(args) != 1 {
+			fail("usage: webshare send [flags] file")
+		}
+		*stdinMode, *archive, *proxyTo, *routes = false, "", "", ""
+		if !flagSet("once") && !flagSet("max-downloads") && !flagSet("max-file-downloads") {
+			*once = true
+		}
+		return args[0]
+	case "receive":
+		if len(args) > 1 {
+			fail("usage: webshare receive [flags] [directory]")
+		}
+		dir := "."
+		if len(args) == 1 {
+			dir = args[0]
+		}
+		// Only the upload form is served, without a listing.
+		*stdinMode, *archive, *proxyTo, *routes, *upload = false, "", "", "", true
+		mounts, vhosts, proxies = mountFlag{{dir: dir}}, nil, nil
+	}
+	return ""
+}
+
+// runQR prints the qr code of text, or saves it as an image with -o.
+func runQR(args []string) {
+	fs := flag.NewFlagSet("qr", flag.ExitOnError)
+	out := fs.String("o", "", "save the qr code as a .png or .svg image instead of printing it")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: webshare qr [flags] text\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	text := strings.Join(fs.Args(), " ")
+	if *out != "" {
+		if err := writeQR(*out, text); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	qrterminal.GenerateWithConfig(text, qrterminal.Config{
+		Level:     qrterminal.M,
+		Writer:    os.Stdout,
+		BlackChar: qrterminal.WHITE,
+		WhiteChar: qrterminal.BLACK,
+		QuietZone: 1,
+	})
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// command is a subcommand of webshare, for usage and completion.
+type command struct {
+	name, usage string
+	// args are the words completed as arguments.
+	args []string
+	// flags are the flags of the subcommand.
+	flags []string
+	// serve is set if the subcommand takes the flags of webshare, after its
+	// first argument if it has args, like service install.
+	serve bool
+}
+
+var commands = []command{
+	{name: "serve", usage: "share directories, the current one by default", serve: true},
+	{name: "send", usage: "share a file until it was downloaded once", serve: true},
+	{name: "receive", usage: "only take uploads into a directory, the current one by default", serve: true},
+	{name: "qr", usage: "print the qr code of a text", flags: []string{"o"}},
+	{name: "sign", usage: "print a signed link to a path", flags: []string{"ttl", "sign-key", "base", "p", "tls"}},
+	{name: "ctl", usage: "change a share started with -admin", args: []string{"status", "extend", "rotate-token", "uploads", "shutdown"}, flags: []string{"url", "token"}},
+	{name: "stop", usage: "stop the share started with -daemon", flags: []string{"pid-file"}},
+	{name: "status", usage: "tell whether the share started with -daemon runs", flags: []string{"pid-file"}},
+	{name: "service", usage: "run a share as a service", args: []string{"install", "uninstall", "start"}, serve: true},
+	{name: "completion", usage: "print a shell completion script", args: []string{"bash", "zsh", "fish"}},
+}
+
+// fileFlags take a file name, dirFlags a directory.
+var (
+	fileFlags = map[string]bool{
+		"access-log": true, "archive": true, "cert": true, "config": true,
+		"geoip": true, "key": true, "mime-file": true, "pid-file": true,
+		"qr-out": true, "routes": true, "sign-key": true, "summary-out": true,
+		"template": true,
+	}
+	dirFlags = map[string]bool{"d": true}
+)
+
+// flagChoices are the values of flags taking one of a few words.
+var flagChoices = map[string][]string{
+	"follow-symlinks": {"never", "inside", "always"},
+	"hidden":          {"show", "hide", "deny"},
+	"log":             {"stderr", "syslog"},
+	"log-format":      {"text", "json"},
+	"syslog-facility": {"daemon", "user", "local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7"},
+}
+
+// servingFlags returns the flags of webshare itself, sorted.
+func servingFlags() []*flag.Flag {
+	var flags []*flag.Flag
+	flag.VisitAll(func(f *flag.Flag) { flags = append(flags, f) })
+	sort.Slice(flags, func(i, j int) bool { return flags[i].Name < flags[j].Name })
+	return flags
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// firstLine returns the usage of a flag up to the first comma, short
+// enough for a completion menu.
+func firstLine(usage string) string {
+	usage, _, _ = strings.Cut(usage, ", ")
+	return usage
+}
+
+// runCompletion prints the completion script for the shell in args.
+func runCompletion(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "usage: webshare completion bash|zsh|fish\n")
+		os.Exit(2)
+	}
+	switch args[0] {
+	case "bash":
+		fmt.Print(bashCompletion())
+	case "zsh":
+		fmt.Print(zshCompletion())
+	case "fish":
+		fmt.Print(fishCompletion())
+	default:
+		fmt.Fprintf(os.Stderr, "unsupported shell %q, want bash, zsh or fish\n", args[0])
+		os.Exit(2)
+	}
+}
+
+func dashed(names []string) string {
+	var parts []string
+	for _, name := range names {
+		parts = append(parts, "-"+name)
+	}
+	return strings.Join(parts, " ")
+}
+
+func bashCompletion() string {
+	var b strings.Builder
+	var all, files, dirs, values []string
+	for _, f := range servingFlags() {
+		all = append(all, f.Name)
+		switch {
+		case fileFlags[f.Name]:
+			files = append(files, "-"+f.Name)
+		case dirFlags[f.Name]:
+			dirs = append(dirs, "-"+f.Name)
+		case flagChoices[f.Name] == nil && !isBoolFlag(f):
+			values = append(values, "-"+f.Name)
+		}
+	}
+	b.WriteString("# bash completion for webshare, load with: source <(webshare completion bash)\n")
+	b.WriteString("_webshare() {\n")
+	b.WriteString("\tlocal cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]}\n")
+	b.WriteString("\tcase $prev in\n")
+	for _, f := range servingFlags() {
+		if choices := flagChoices[f.Name]; choices != nil {
+			fmt.Fprintf(&b, "\t-%s) COMPREPLY=($(compgen -W %q -- \"$cur\")); return ;;\n", f.Name, strings.Join(choices, " "))
+		}
+	}
+	fmt.Fprintf(&b, "\t%s) COMPREPLY=($(compgen -f -- \"$cur\")); return ;;\n", strings.Join(files, "|"))
+	fmt.Fprintf(&b, "\t%s) COMPREPLY=($(compgen -d -- \"$cur\")); return ;;\n", strings.Join(dirs, "|"))
+	fmt.Fprintf(&b, "\t%s) COMPREPLY=(); return ;;\n", strings.Join(values, "|"))
+	b.WriteString("\tesac\n")
+	b.WriteString("\tif ((COMP_CWORD > 1)); then\n")
+	b.WriteString("\tcase ${COMP_WORDS[1]} in\n")
+	for _, c := range commands {
+		fmt.Fprintf(&b, "\t%s)\n", c.name)
+		if c.serve && c.args == nil {
+			b.WriteString("\t\tif [[ $cur == -* ]]; then\n")
+			fmt.Fprintf(&b, "\t\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n", dashed(all))
+			b.WriteString("\t\telse\n")
+			b.WriteString("\t\t\tCOMPREPLY=($(compgen -f -- \"$cur\"))\n")
+			b.WriteString("\t\tfi\n")
+			b.WriteString("\t\treturn ;;\n")
+			continue
+		}
+		if c.serve {
+			b.WriteString("\t\tif ((COMP_CWORD > 2)); then\n")
+			fmt.Fprintf(&b, "\t\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n", dashed(all))
+			b.WriteString("\t\t\treturn\n")
+			b.WriteString("\t\tfi\n")
+		}
+		fmt.Fprintf(&b, "\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.TrimSpace(dashed(c.flags)+" "+strings.Join(c.args, " ")))
+		b.WriteString("\t\treturn ;;\n")
+	}
+	b.WriteString("\tesac\n")
+	b.WriteString("\tfi\n")
+	b.WriteString("\tif ((COMP_CWORD == 1)) && [[ $cur != -* ]]; then\n")
+	var names []string
+	for _, c := range commands {
+		names = append(names, c.name)
+	}
+	fmt.Fprintf(&b, "\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(names, " "))
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\tfi\n")
+	fmt.Fprintf(&b, "\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n", dashed(all))
+	b.WriteString("}\n")
+	b.WriteString("complete -F _webshare webshare\n")
+	return b.String()
+}
+
+// zshQuote escapes s for a single quoted _arguments spec.
+func zshQuote(s string) string {
+	return strings.NewReplacer("'", `'\''`, "[", `\[`, "]", `\]`, ":", `\:`).Replace(s)
+}
+
+func zshCompletion() string {
+	var b strings.Builder
+	b.WriteString("#compdef webshare\n")
+	b.WriteString("# zsh completion for webshare, load with: source <(webshare completion zsh)\n")
+	b.WriteString("_webshare_flags=(\n")
+	for _, f := range servingFlags() {
+		action := ":value:"
+		switch {
+		case isBoolFlag(f):
+			action = ""
+		case fileFlags[f.Name]:
+			action = ":file:_files"
+		case dirFlags[f.Name]:
+			action = ":directory:_files -/"
+		case flagChoices[f.Name] != nil:
+			action = fmt.Sprintf(":value:(%s)", strings.Join(flagChoices[f.Name], " "))
+		}
+		fmt.Fprintf(&b, "\t'-%s[%s]%s'\n", f.Name, zshQuote(firstLine(f.Usage)), action)
+	}
+	b.WriteString(")\n")
+	b.WriteString("_webshare() {\n")
+	b.WriteString("\tif ((CURRENT > 2)); then\n")
+	b.WriteString("\tcase $words[2] in\n")
+	for _, c := range commands {
+		fmt.Fprintf(&b, "\t%s)\n", c.name)
+		if c.serve && c.args == nil {
+			b.WriteString("\t\twords=($words[1] $words[3,-1]) && ((CURRENT--))\n")
+			b.WriteString("\t\t_arguments $_webshare_flags '*:file:_files'\n")
+			b.WriteString("\t\treturn ;;\n")
+			continue
+		}
+		if c.serve {
+			b.WriteString("\t\tif ((CURRENT > 3)); then\n")
+			b.WriteString("\t\t\twords=($words[1] $words[4,-1]) && ((CURRENT -= 2))\n")
+			b.WriteString("\t\t\t_arguments $_webshare_flags\n")
+			b.WriteString("\t\t\treturn\n")
+			b.WriteString("\t\tfi\n")
+		}
+		b.WriteString("\t\twords=($words[1] $words[3,-1]) && ((CURRENT--))\n")
+		b.WriteString("\t\t_arguments")
+		for _, name := range c.flags {
+			fmt.Fprintf(&b, " '-%s:value:'", name)
+		}
+		if c.args != nil {
+			fmt.Fprintf(&b, " '1:argument:(%s)'", strings.Join(c.args, " "))
+		}
+		b.WriteString("\n\t\treturn ;;\n")
+	}
+	b.WriteString("\tesac\n")
+	b.WriteString("\tfi\n")
+	b.WriteString("\tif ((CURRENT == 2)) && [[ $words[2] != -* ]]; then\n")
+	b.WriteString("\t\tlocal -a commands=(\n")
+	for _, c := range commands {
+		fmt.Fprintf(&b, "\t\t\t'%s:%s'\n", c.name, zshQuote(c.usage))
+	}
+	b.WriteString("\t\t)\n")
+	b.WriteString("\t\t_describe command commands\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\tfi\n")
+	b.WriteString("\t_arguments $_webshare_flags\n")
+	b.WriteString("}\n")
+	b.WriteString("compdef _webshare webshare\n")
+	return b.String()
+}
+
+// fishQuote quotes s for fish.
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
+
+func fishCompletion() string {
+	var b strings.Builder
+	var noServe []string
+	for _, c := range commands {
+		if !c.serve {
+			noServe = append(noServe, c.name)
+		}
+	}
+	b.WriteString("# fish completion for webshare, load with: webshare completion fish | source\n")
+	b.WriteString("complete -c webshare -f\n")
+	for _, c := range commands {
+		fmt.Fprintf(&b, "complete -c webshare -n __fish_use_subcommand -a %s -d %s\n", c.name, fishQuote(c.usage))
+		cond := fishQuote("__fish_seen_subcommand_from " + c.name)
+		if c.args != nil {
+			fmt.Fprintf(&b, "complete -c webshare -n %s -a %s\n", cond, fishQuote(strings.Join(c.args, " ")))
+		} else if c.serve {
+			fmt.Fprintf(&b, "complete -c webshare -n %s -F\n", cond)
+		}
+		for _, name := range c.flags {
+			fmt.Fprintf(&b, "complete -c webshare -n %s -o %s -x\n", cond, name)
+		}
+	}
+	cond := fishQuote("not __fish_seen_subcommand_from " + strings.Join(noServe, " "))
+	for _, f := range servingFlags() {
+		value := " -x"
+		switch {
+		case isBoolFlag(f):
+			value = ""
+		case fileFlags[f.Name]:
+			value = " -r -F"
+		case dirFlags[f.Name]:
+			value = " -x -a '(__fish_complete_directories)'"
+		case flagChoices[f.Name] != nil:
+			value = " -x -a " + fishQuote(strings.Join(flagChoices[f.Name], " "))
+		}
+		fmt.Fprintf(&b, "complete -c webshare -n %s -o %s -d %s%s\n", cond, f.Name, fishQuote(firstLine(f.Usage)), value)
+	}
+	return b.String()
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// cmdlineFlags are the flags given on the command line, which neither the
+// environment nor the config file override, also not on reload.
+var cmdlineFlags = make(map[string]bool)
+
+// applyConfig sets the flags not given on the command line from WEBSHARE_*
+// environment variables, like WEBSHARE_MAX_DOWNLOADS for -max-downloads,
+// or else from the config file fn, config.toml in the user config
+// directory if empty. A missing default config file is fine.
+func applyConfig(fn string) error {
+	flag.Visit(func(f *flag.Flag) { cmdlineFlags[f.Name] = true })
+	config, fn, err := loadConfig(fn)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	flag.VisitAll(func(f *flag.Flag) {
+		if cmdlineFlags[f.Name] {
+			return
+		}
+		env := envName(f.Name)
+		if v, ok := os.LookupEnv(env); ok {
+			if err := flag.Set(f.Name, v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", env, err))
+			}
+			return
+		}
+		// Repeatable flags take arrays, one value per element.
+		for _, v := range config[f.Name] {
+			if err := flag.Set(f.Name, v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %s: %w", fn, f.Name, err))
+			}
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// loadConfig reads the config file fn, or the default one if empty, and
+// returns its values by flag name along with the file name. A missing
+// default config file is read as empty.
+func loadConfig(fn string) (map[string][]string, string, error) {
+	explicit := fn != ""
+	if !explicit {
+		var err error
+		if fn, err = configFile("config.toml"); err != nil {
+			return nil, fn, nil
+		}
+	}
+	config, err := readConfig(fn)
+	if errors.Is(err, fs.ErrNotExist) && !explicit {
+		return nil, fn, nil
+	}
+	if err != nil {
+		return nil, fn, err
+	}
+	for name := range config {
+		if flag.Lookup(name) == nil {
+			return nil, fn, fmt.Errorf("%s: unknown flag %q", fn, name)
+		}
+	}
+	return config, fn, nil
+}
+
+// envName returns the environment variable for the flag name.
+func envName(name string) string {
+	return "WEBSHARE_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// readConfig reads the flat subset of TOML fitting flags: key = value
+// lines with strings, numbers, booleans and arrays of them, and comments.
+func readConfig(fn string) (map[string][]string, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	config := make(map[string][]string)
+	s := bufio.NewScanner(f)
+	lineNo := 0
+	for s.Scan() {
+		lineNo++
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			return nil, fmt.Errorf("%s:%d: tables are not supported, flags go at the top level", fn, lineNo)
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: want key = value", fn, lineNo)
+		}
+		key = strings.Trim(strings.TrimSpace(key), `"`)
+		value = stripComment(value)
+		// Arrays may span lines until the closing bracket.
+		for strings.HasPrefix(value, "[") && !strings.HasSuffix(value, "]") && s.Scan() {
+			lineNo++
+			value += " " + stripComment(s.Text())
+		}
+		values, err := parseTOMLValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s: %w", fn, lineNo, key, err)
+		}
+		config[key] = values
+	}
+	return config, s.Err()
+}
+
+// stripComment removes a trailing comment outside of strings from s.
+func stripComment(s string) string {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == '#':
+			return strings.TrimSpace(s[:i])
+		}
+	}
+	return strings.TrimSpace(s)
+}
+
+// parseTOMLValue returns the value v as flag values, one per element of
+// an array.
+func parseTOMLValue(v string) ([]string, error) {
+	if !strings.HasPrefix(v, "[") {
+		s, rest, err := parseTOMLScalar(v)
+		if err != nil {
+			return nil, err
+		}
+		if rest != "" {
+			return nil, fmt.Errorf("unexpected %q after the value", rest)
+		}
+		return []string{s}, nil
+	}
+	v = strings.TrimSpace(v[1:])
+	values := []string{}
+	for {
+		if strings.HasPrefix(v, "]") {
+			if rest := strings.TrimSpace(v[1:]); rest != "" {
+				return nil, fmt.Errorf("unexpected %q after the array", rest)
+			}
+			return values, nil
+		}
+		s, rest, err := parseTOMLScalar(v)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, s)
+		rest = strings.TrimSpace(rest)
+		if strings.HasPrefix(rest, ",") {
+			rest = strings.TrimSpace(rest[1:])
+		} else if !strings.HasPrefix(rest, "]") {
+			return nil, errors.New("want , or ] in the array")
+		}
+		v = rest
+	}
+}
+
+// parseTOMLScalar parses the string, number or boolean at the start of v
+// and returns it as text with the rest of v.
+func parseTOMLScalar(v string) (string, string, error) {
+	switch {
+	case strings.HasPrefix(v, `"`):
+		for i := 1; i < len(v); i++ {
+			switch v[i] {
+			case '\\':
+				i++
+			case '"':
+				s, err := strconv.Unquote(v[:i+1])
+				if err != nil {
+					return "", "", fmt.Errorf("invalid string %s", v[:i+1])
+				}
+				return s, strings.TrimSpace(v[i+1:]), nil
+			}
+		}
+		return "", "", errors.New("unterminated string")
+	case strings.HasPrefix(v, "'"):
+		// Literal strings have no escapes.
+		end := strings.IndexByte(v[1:], '\'')
+		if end < 0 {
+			return "", "", errors.New("unterminated string")
+		}
+		return v[1 : end+1], strings.TrimSpace(v[end+2:]), nil
+	}
+	end := strings.IndexAny(v, ",]")
+	if end < 0 {
+		end = len(v)
+	}
+	s := strings.TrimSpace(v[:end])
+	if s == "true" || s == "false" {
+		return s, v[end:], nil
+	}
+	s = strings.ReplaceAll(s, "_", "")
+	if _, err := strconv.ParseFloat(s, 64); err != nil {
+		return "", "", fmt.Errorf("want a string, number or boolean, got %q", s)
+	}
+	return s, v[end:], nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// corsHandler wraps h and lets web pages from origins fetch from the share,
+// any origin if origins contains "*". Preflight requests are answered here,
+// since they come without the credentials the handlers behind may want.
+func corsHandler(origins []string, writable bool, h http.Handler) http.Handler {
+	allowed := make(map[string]bool)
+	for _, o := range origins {
+		allowed[strings.TrimSuffix(o, "/")] = true
+	}
+	methods := "GET, HEAD, OPTIONS"
+	if writable {
+		methods += ", POST, PUT, DELETE"
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		w.Header().Add("Vary", "Origin")
+		if origin == "" || !(allowed["*"] || allowed[origin]) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if allowed["*"] {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", methods)
+			if hdr := r.Header.Get("Access-Control-Request-Headers"); hdr != "" {
+				w.Header().Set("Access-Control-Allow-Headers", hdr)
+			}
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Access-Control-Expose-Headers", "Content-Length, Content-Range, Content-Disposition, Accept-Ranges, ETag, Last-Modified")
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+// defaultPidFile is where -daemon keeps the pid of the background
+// webshare for webshare stop and status.
+func defaultPidFile() string {
+	fn, err := configFile("webshare.pid")
+	if err != nil {
+		log.Fatal(err)
+	}
+	return fn
+}
+
+// runControl runs webshare stop or status on the webshare started with
+// -daemon.
+func runControl(command string, args []string) {
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	pidFile := fs.String("pid-file", defaultPidFile(), "pid file of the running webshare")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: webshare %s [flags]\n", command)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	switch command {
+	case "stop":
+		if err := stopDaemon(*pidFile); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("stopped")
+	case "status":
+		pid, err := runningPid(*pidFile)
+		if errors.Is(err, errNotRunning) {
+			fmt.Println("not running")
+			os.Exit(3)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("running as pid %d\n", pid)
+	}
+}
@@ -0,0 +1,28 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"os"
+	"strconv"
+)
+
+var errNotRunning = errors.New("not running")
+
+// daemonize is only implemented on unix systems.
+func daemonize(command, pidFile, logFile string) error {
+	return errors.New("-daemon is not supported on this platform")
+}
+
+func writePidFile(fn string) error {
+	return os.WriteFile(fn, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+}
+
+func runningPid(pidFile string) (int, error) {
+	return 0, errors.New("webshare status is not supported on this platform")
+}
+
+func stopDaemon(pidFile string) error {
+	return errors.New("webshare stop is not supported on this platform")
+}
@@ -0,0 +1,135 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// daemonize starts webshare command again with the same arguments in a
+// new session, with its output appended to logFile and its pid in
+// pidFile, and returns once it is serving.
+func daemonize(command, pidFile, logFile string) error {
+	if pid, err := runningPid(pidFile); err == nil {
+		return fmt.Errorf("already running as pid %d", pid)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	args := []string{command, "-pid-file", pidFile}
+	_, rest := splitCommand(os.Args[1:])
+	for _, arg := range rest {
+		name, _, _ := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if strings.HasPrefix(arg, "-") && name == "daemon" {
+			continue
+		}
+		args = append(args, arg)
+	}
+	if err := os.MkdirAll(filepath.Dir(logFile), 0700); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(logFile, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	start, err := out.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(exe, args...)
+	// The variable wins over a config file that enables -daemon.
+	cmd.Env = append(os.Environ(), "WEBSHARE_DAEMON=false")
+	cmd.Stdout, cmd.Stderr = out, out
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	// Pass on what it logged on start, the links and qr codes, or why it
+	// failed. Only the channel tells whether Wait returned.
+	var waitErr error
+	done := false
+	select {
+	case waitErr = <-exited:
+		done = true
+	case <-time.After(2 * time.Second):
+	}
+	if _, err := out.Seek(start, io.SeekStart); err == nil {
+		io.Copy(os.Stderr, out)
+	}
+	if !done {
+		select {
+		case waitErr = <-exited:
+			done = true
+		default:
+		}
+	}
+	if done {
+		if waitErr == nil {
+			return errors.New("webshare exited")
+		}
+		return fmt.Errorf("webshare exited: %w", waitErr)
+	}
+	fmt.Fprintf(os.Stderr, "running in the background as pid %d, logging to %s, stop with webshare stop\n", cmd.Process.Pid, logFile)
+	return nil
+}
+
+// writePidFile writes the pid of this process to fn.
+func writePidFile(fn string) error {
+	if err := os.MkdirAll(filepath.Dir(fn), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(fn, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+}
+
+var errNotRunning = errors.New("not running")
+
+// runningPid returns the pid in pidFile if that process is alive.
+func runningPid(pidFile string) (int, error) {
+	b, err := os.ReadFile(pidFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, errNotRunning
+	}
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid pid: %w", pidFile, err)
+	}
+	// Signal 0 only checks that the process exists.
+	if err := syscall.Kill(pid, 0); err != nil && !errors.Is(err, syscall.EPERM) {
+		return 0, errNotRunning
+	}
+	return pid, nil
+}
+
+// stopDaemon asks the webshare in pidFile to shut down and waits for it.
+func stopDaemon(pidFile string) error {
+	pid, err := runningPid(pidFile)
+	if err != nil {
+		return err
+	}
+	if err := syscall.Kill(pid, syscall.SIGTERM); err != nil {
+		return err
+	}
+	for range 100 {
+		if _, err := runningPid(pidFile); err != nil {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("pid %d is still finishing transfers, run webshare stop again to cut them off", pid)
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mdp/qrterminal"
+	"golang.org/x/term"
+)
+
+// dashboard shows the transfers, requests and clients of the share on the
+// terminal for -tui, redrawn every half second, with the log below.
+type dashboard struct {
+	started time.Time
+	link    string
+	qr      []string
+	sent    atomic.Int64
+
+	mu        sync.Mutex
+	transfers map[*transfer]bool
+	recent    []string
+	logLines  []string
+	clients   map[string]time.Time
+}
+
+// transfer is a response in progress.
+type transfer struct {
+	remote  string
+	path    string
+	started time.Time
+	// size is the announced length, or -1.
+	size    int64
+	written atomic.Int64
+}
+
+// dashboardLines is the number of recent requests and log lines kept.
+const dashboardLines = 8
+
+// clientTimeout is how long clients count as connected after their last
+// request.
+const clientTimeout = 5 * time.Minute
+
+func newDashboard(link string) *dashboard {
+	d := &dashboard{
+		started:   time.Now(),
+		link:      link,
+		transfers: make(map[*transfer]bool),
+		clients:   make(map[string]time.Time),
+	}
+	if link != "" {
+		var buf bytes.Buffer
+		qrterminal.GenerateHalfBlock(link, qrterminal.M, &buf)
+		d.qr = strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	}
+	return d
+}
+
+// Write takes the lines of the standard logger.
+func (d *dashboard) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		d.logLines = appendLast(d.logLines, line)
+	}
+	return len(p), nil
+}
+
+// appendLast appends s to lines, keeping the last dashboardLines.
+func appendLast(lines []string, s string) []string {
+	lines = append(lines, s)
+	if len(lines) > dashboardLines {
+		lines = slices.Delete(lines, 0, len(lines)-dashboardLines)
+	}
+	return lines
+}
+
+// Handler wraps h and tracks its responses.
+func (d *dashboard) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remote := r.RemoteAddr
+		if host, _, err := net.SplitHostPort(remote); err == nil {
+			remote = host
+		}
+		t := &transfer{remote: remote, path: r.URL.Path, started: time.Now(), size: -1}
+		d.mu.Lock()
+		d.transfers[t] = true
+		d.clients[remote] = t.started
+		d.mu.Unlock()
+		tw := &transferWriter{ResponseWriter: w, t: t, d: d}
+		h.ServeHTTP(tw, r)
+		status := tw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		line := fmt.Sprintf("%s %s %s %s %d %s %s", t.started.Format("15:04:05"), remote, r.Method, t.path,
+			status, formatSize(t.written.Load()), time.Since(t.started).Round(time.Millisecond))
+		d.mu.Lock()
+		delete(d.transfers, t)
+		d.recent = appendLast(d.recent, line)
+		d.mu.Unlock()
+	})
+}
+
+// transferChunk is the most a transfer sends at once with -tui, so the
+// progress of files sent via sendfile shows.
+const transferChunk = 1 << 20
+
+// transferWriter counts the bytes of a transfer as they are written.
+type transferWriter struct {
+	http.ResponseWriter
+	t      *transfer
+	d      *dashboard
+	status int
+}
+
+func (tw *transferWriter) WriteHeader(code int) {
+	if tw.status == 0 {
+		tw.status = code
+		if n, err := strconv.ParseInt(tw.Header().Get("Content-Length"), 10, 64); err == nil {
+			tw.t.size = n
+		}
+	}
+	tw.ResponseWriter.WriteHeader(code)
+}
+
+func (tw *transferWriter) Write(b []byte) (int, error) {
+	if tw.status == 0 {
+		tw.WriteHeader(http.StatusOK)
+	}
+	n, err := tw.ResponseWriter.Write(b)
+	tw.t.written.Add(int64(n))
+	tw.d.sent.Add(int64(n))
+	return n, err
+}
+
+func (tw *transferWriter) ReadFrom(r io.Reader) (int64, error) {
+	if tw.status == 0 {
+		tw.WriteHeader(http.StatusOK)
+	}
+	var total int64
+	for {
+		n, err := readChunk(tw.ResponseWriter, r, transferChunk)
+		total += n
+		tw.t.written.Add(n)
+		tw.d.sent.Add(n)
+		if err != nil || n < transferChunk {
+			return total, err
+		}
+	}
+}
+
+func (tw *transferWriter) Flush() {
+	if f, ok := tw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap allows http.ResponseController to reach the original writer.
+func (tw *transferWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
+
+// run draws the dashboard on the alternate screen of the terminal until
+// ctx is done.
+func (d *dashboard) run(ctx context.Context, w io.Writer) {
+	fmt.Fprint(w, "\x1b[?1049h\x1b[?25l")
+	defer fmt.Fprint(w, "\x1b[?25h\x1b[?1049l")
+	tick := time.NewTicker(500 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		d.draw(w)
+		select {
+		case <-tick.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// draw renders one frame, with the qr code in the top right corner if the
+// terminal is wide enough.
+func (d *dashboard) draw(w io.Writer) {
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || width <= 0 || height <= 0 {
+		width, height = 80, 24
+	}
+	d.mu.Lock()
+	now := time.Now()
+	clients := 0
+	for ip, seen := range d.clients {
+		if now.Sub(seen) > clientTimeout {
+			delete(d.clients, ip)
+			continue
+		}
+		clients++
+	}
+	var active []*transfer
+	for t := range d.transfers {
+		active = append(active, t)
+	}
+	slices.SortFunc(active, func(a, b *transfer) int { return a.started.Compare(b.started) })
+	lines := []string{
+		"webshare " + d.link,
+		fmt.Sprintf("up %s, sent %s, %d clients", now.Sub(d.started).Round(time.Second), formatSize(d.sent.Load()), clients),
+		"",
+		fmt.Sprintf("Active transfers (%d)", len(active)),
+	}
+	for _, t := range active {
+		lines = append(lines, "  "+t.progress(now))
+	}
+	lines = append(lines, "", "Recent requests")
+	for _, line := range d.recent {
+		lines = append(lines, "  "+line)
+	}
+	lines = append(lines, "", "Log")
+	for _, line := range d.logLines {
+		lines = append(lines, "  "+line)
+	}
+	d.mu.Unlock()
+
+	qrWidth := 0
+	if len(d.qr) > 0 {
+		qrWidth = utf8.RuneCountInString(d.qr[0])
+	}
+	showQR := qrWidth > 0 && width >= qrWidth+40 && height >= len(d.qr)
+	textWidth := width
+	if showQR {
+		textWidth = width - qrWidth - 1
+	}
+	var buf bytes.Buffer
+	// Lines are overwritten rather than the screen cleared, which flickers.
+	for i, line := range lines {
+		if i >= height {
+			break
+		}
+		fmt.Fprintf(&buf, "\x1b[%d;1H%s\x1b[K", i+1, truncate(line, textWidth))
+	}
+	buf.WriteString("\x1b[J")
+	if showQR {
+		for i, line := range d.qr {
+			fmt.Fprintf(&buf, "\x1b[%d;%dH%s", i+1, width-qrWidth+1, line)
+		}
+	}
+	w.Write(buf.Bytes())
+}
+
+// progress describes the transfer with its progress and remaining time if
+// the size is known.
+func (t *transfer) progress(now time.Time) string {
+	written := t.written.Load()
+	elapsed := now.Sub(t.started).Seconds()
+	rate := 0.0
+	if elapsed > 0 {
+		rate = float64(written) / elapsed
+	}
+	s := fmt.Sprintf("%s %s %s, %s/s", t.remote, t.path, formatSize(written), formatSize(int64(rate)))
+	if t.size > 0 {
+		s += fmt.Sprintf(", %d%% of %s", written*100/t.size, formatSize(t.size))
+		if rate > 0 {
+			eta := time.Duration(float64(t.size-written) / rate * float64(time.Second))
+			s += ", " + eta.Round(time.Second).String() + " left"
+		}
+	}
+	return s
+}
+
+// truncate cuts s to n runes.
+func truncate(s string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd)
+
+package main
+
+import "errors"
+
+// diskSpace is only implemented on linux, macos and freebsd.
+func diskSpace(dir string) (uint64, uint64, error) {
+	return 0, 0, errors.ErrUnsupported
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync"
+)
+
+// connTracker follows the state of the connections of a server, so the
+// shutdown can tell how many transfers it waits for.
+type connTracker struct {
+	mu     sync.Mutex
+	active map[net.Conn]bool
+}
+
+// ConnState is the http.Server hook.
+func (t *connTracker) ConnState(c net.Conn, state http.ConnState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.active == nil {
+		t.active = make(map[net.Conn]bool)
+	}
+	if state == http.StateActive {
+		t.active[c] = true
+	} else {
+		delete(t.active, c)
+	}
+}
+
+// count returns the number of connections with a request in progress.
+func (t *connTracker) count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.active)
+}
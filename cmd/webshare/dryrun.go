@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+)
+
+// plannedAddr resolves an address of -listen, -b and -p the way listen
+// would bind it, for -dry-run.
+func plannedAddr(addr string) (net.Addr, error) {
+	if fn, ok := strings.CutPrefix(addr, "unix:"); ok {
+		return &net.UnixAddr{Name: fn, Net: "unix"}, nil
+	}
+	return net.ResolveTCPAddr("tcp", addr)
+}
+
+// reportDryRun logs the effective configuration of the share: the flags
+// that were set, with secrets redacted, what is shared and who gets in.
+// The links go to stdout, one per line, for scripts.
+func reportDryRun(command, sendFn string, links []string) {
+	flag.Visit(func(f *flag.Flag) {
+		v := f.Value.String()
+		if redactedFlags[f.Name] {
+			v = "redacted"
+		}
+		log.Printf("flag -%s=%s", f.Name, v)
+	})
+	switch {
+	case *stdinMode:
+		log.Printf("share: stdin as %s", *stdinName)
+	case sendFn != "" && (*once || *maxDL > 0):
+		n := *maxDL
+		if *once {
+			n = 1
+		}
+		log.Printf("share: %s, shutting down after %d downloads", sendFn, n)
+	case sendFn != "":
+		log.Printf("share: %s", sendFn)
+	case *archive != "":
+		log.Printf("share: contents of %s", *archive)
+	case *proxyTo != "":
+		log.Printf("share: proxy to %s", *proxyTo)
+	case command == "receive":
+		log.Printf("share: uploads into %s", mounts[0].dir)
+	default:
+		for _, m := range mounts {
+			log.Printf("share: %s at %s/", m.dir, m.prefix)
+		}
+		for _, p := range proxies {
+			log.Printf("share: proxy to %s at %s/", p.target, p.prefix)
+		}
+		for _, v := range vhosts {
+			log.Printf("share: %s for host %s", v.dir, v.host)
+		}
+	}
+	hidden, include, exclude := policy.rules()
+	log.Printf("dotfiles: %s", hidden)
+	if len(include) > 0 {
+		log.Printf("include: %s", strings.Join(include, " "))
+	}
+	if len(exclude) > 0 {
+		log.Printf("exclude: %s", strings.Join(exclude, " "))
+	}
+	var auth []string
+	if *token {
+		auth = append(auth, "secret path prefix")
+	}
+	if *totp {
+		auth = append(auth, "authenticator code")
+	}
+	if *signed {
+		auth = append(auth, "signed links only")
+	}
+	if *mtls {
+		auth = append(auth, "client certificate")
+	}
+	if *private {
+		auth = append(auth, "private addresses only")
+	}
+	if len(allowIPs) > 0 || len(denyIPs) > 0 {
+		auth = append(auth, "-allow and -deny networks")
+	}
+	if len(auth) == 0 {
+		auth = append(auth, "none, anyone reaching the share gets in")
+	}
+	log.Printf("access: %s", strings.Join(auth, ", "))
+	log.Printf("uploads: %v", *upload)
+	if *port == 0 && len(links) > 0 {
+		log.Printf("the port is picked on start, the links show port 0")
+	}
+	log.Printf("dry run, exiting without listening")
+	for _, link := range links {
+		fmt.Println(link)
+	}
+}
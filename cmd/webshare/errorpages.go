@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// errorPageFlag collects repeated -error-page code=file flags.
+type errorPageFlag map[int]string
+
+func (f errorPageFlag) String() string {
+	var parts []string
+	for code, fn := range f {
+		parts = append(parts, fmt.Sprintf("%d=%s", code, fn))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+func (f errorPageFlag) Set(v string) error {
+	c, fn, ok := strings.Cut(v, "=")
+	code, err := strconv.Atoi(c)
+	if !ok || err != nil || code < 400 || code > 599 {
+		return fmt.Errorf("invalid error page %q, want code=file, e.g. 404=notfound.html", v)
+	}
+	f[code] = fn
+	return nil
+}
+
+// loadErrorPages reads the configured error pages into memory.
+func loadErrorPages(f errorPageFlag) (map[int][]byte, error) {
+	pages := make(map[int][]byte)
+	for code, fn := range f {
+		b, err := os.ReadFile(fn)
+		if err != nil {
+			return nil, err
+		}
+		pages[code] = b
+	}
+	return pages, nil
+}
+
+// errorPageHandler wraps h and replaces the body of error responses, for
+// which a page is configured, with that page. A {{request_id}} in the page
+// becomes the id of the request.
+func errorPageHandler(pages map[int][]byte, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(&errorPageWriter{ResponseWriter: w, pages: pages, head: r.Method == http.MethodHead, id: requestID(r)}, r)
+	})
+}
+
+// errorPageWriter swallows the body the handler writes for a replaced error
+// response.
+type errorPageWriter struct {
+	http.ResponseWriter
+	pages       map[int][]byte
+	head        bool
+	id          string
+	wroteHeader bool
+	replaced    bool
+}
+
+func (ew *errorPageWriter) WriteHeader(code int) {
+	if ew.wroteHeader {
+		return
+	}
+	ew.wroteHeader = true
+	page, ok := ew.pages[code]
+	if !ok {
+		ew.ResponseWriter.WriteHeader(code)
+		return
+	}
+	ew.replaced = true
+	page = bytes.ReplaceAll(page, []byte("{{request_id}}"), []byte(ew.id))
+	h := ew.Header()
+	h.Del("Content-Encoding")
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	h.Set("Content-Length", strconv.Itoa(len(page)))
+	ew.ResponseWriter.WriteHeader(code)
+	if !ew.head {
+		ew.ResponseWriter.Write(page)
+	}
+}
+
+func (ew *errorPageWriter) Write(b []byte) (int, error) {
+	if !ew.wroteHeader {
+		ew.WriteHeader(http.StatusOK)
+	}
+	if ew.replaced {
+		return len(b), nil
+	}
+	return ew.ResponseWriter.Write(b)
+}
+
+func (ew *errorPageWriter) ReadFrom(r io.Reader) (int64, error) {
+	if !ew.wroteHeader {
+		ew.WriteHeader(http.StatusOK)
+	}
+	if ew.replaced {
+		return io.Copy(io.Discard, r)
+	}
+	if rf, ok := ew.ResponseWriter.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(ew.ResponseWriter, r)
+}
+
+func (ew *errorPageWriter) Flush() {
+	if f, ok := ew.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (ew *errorPageWriter) Unwrap() http.ResponseWriter {
+	return ew.ResponseWriter
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// event is something that happened on the share, passed to the sinks
+// like -webhook.
+type event struct {
+	Event  string    `json:"event"`
+	Time   time.Time `json:"time"`
+	Remote string    `json:"remote,omitempty"`
+	Path   string    `json:"path,omitempty"`
+	Bytes  int64     `json:"bytes,omitempty"`
+	Link   string    `json:"link,omitempty"`
+	Reason string    `json:"reason,omitempty"`
+	// Text describes the event in a sentence, which is what chat services
+	// show of it.
+	Text string `json:"text"`
+}
+
+// eventSinks receive every event, they must not block.
+var eventSinks []func(event)
+
+// emit passes e to the sinks.
+func emit(e event) {
+	if len(eventSinks) == 0 {
+		return
+	}
+	e.Time = time.Now()
+	for _, sink := range eventSinks {
+		sink(e)
+	}
+}
+
+// remoteHost returns the address of the client of r without the port.
+func remoteHost(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// emitAuthFailure reports a request that was refused for the reason.
+func emitAuthFailure(r *http.Request, reason string) {
+	emit(event{
+		Event:  "auth_failure",
+		Remote: remoteHost(r),
+		Path:   r.URL.Path,
+		Reason: reason,
+		Text:   fmt.Sprintf("%s was refused: %s", remoteHost(r), reason),
+	})
+}
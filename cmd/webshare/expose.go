@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// portMapper asks a router to forward an external tcp port to this host.
+type portMapper interface {
+	// externalIP returns the address of the router on the internet.
+	externalIP() (net.IP, error)
+	// add forwards the external port to the local one for lifetime and
+	// returns the external port the router picked.
+	add(external, local int, lifetime time.Duration) (int, error)
+	// remove deletes the forwarding of the external port.
+	remove(external, local int) error
+	String() string
+}
+
+// portMapping is a forwarding set up by -expose, renewed until it is closed.
+type portMapping struct {
+	mapper   portMapper
+	local    int
+	external int
+	ip       net.IP
+	done     chan struct{}
+}
+
+// mappingLifetime is how long forwardings are asked for, they are renewed
+// at half of it so they disappear soon after webshare is killed.
+const mappingLifetime = time.Hour
+
+// expose finds the router and asks it to forward port, trying NAT-PMP
+// first, which answers quickly if supported, then UPnP.
+func expose(ctx context.Context, port int) (*portMapping, error) {
+	var errs []error
+	if gw, err := defaultGateway(); err == nil {
+		pm, err := mapPort(ctx, &natPMP{gateway: gw}, port)
+		if err == nil {
+			return pm, nil
+		}
+		errs = append(errs, fmt.Errorf("nat-pmp: %w", err))
+	}
+	g, err := discoverIGD(ctx)
+	if err == nil {
+		var pm *portMapping
+		if pm, err = mapPort(ctx, g, port); err == nil {
+			return pm, nil
+		}
+	}
+	errs = append(errs, fmt.Errorf("upnp: %w", err))
+	return nil, errors.Join(errs...)
+}
+
+// mapPort sets up the forwarding of port with m and renews it until ctx is
+// done.
+func mapPort(ctx context.Context, m portMapper, port int) (*portMapping, error) {
+	ip, err := m.externalIP()
+	if err != nil {
+		return nil, err
+	}
+	external, err := m.add(port, port, mappingLifetime)
+	if err != nil {
+		return nil, err
+	}
+	pm := &portMapping{mapper: m, local: port, external: external, ip: ip, done: make(chan struct{})}
+	go func() {
+		defer close(pm.done)
+		t := time.NewTicker(mappingLifetime / 2)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				if _, err := m.add(pm.external, port, mappingLifetime); err != nil {
+					log.Printf("renewing port forwarding: %v", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return pm, nil
+}
+
+// close removes the forwarding once renewing has stopped.
+func (pm *portMapping) close() {
+	<-pm.done
+	if err := pm.mapper.remove(pm.external, pm.local); err != nil {
+		log.Printf("removing port forwarding: %v", err)
+		return
+	}
+	log.Printf("removed port forwarding of %d", pm.external)
+}
+
+// natPMP maps ports with the NAT Port Mapping Protocol from RFC 6886.
+type natPMP struct {
+	gateway net.IP
+}
+
+func (n *natPMP) String() string {
+	return "nat-pmp " + n.gateway.String()
+}
+
+// call sends req to the gateway and returns the response, retrying a few
+// times with a growing timeout.
+func (n *natPMP) call(req []byte, size int) ([]byte, error) {
+	conn, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: n.gateway, Port: 5351})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	resp := make([]byte, 16)
+	wait := 250 * time.Millisecond
+	for range 3 {
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+		conn.SetReadDeadline(time.Now().Add(wait))
+		wait *= 2
+		nr, err := conn.Read(resp)
+		var ne net.Error
+		if err != nil && !(errors.As(err, &ne) && ne.Timeout()) {
+			// Routers without NAT-PMP usually refuse right away.
+			return nil, err
+		}
+		if err != nil || nr < size || resp[1] != req[1]|0x80 {
+			continue
+		}
+		if code := binary.BigEndian.Uint16(resp[2:4]); code != 0 {
+			return nil, fmt.Errorf("result code %d", code)
+		}
+		return resp[:nr], nil
+	}
+	return nil, errors.New("no response")
+}
+
+func (n *natPMP) externalIP() (net.IP, error) {
+	resp, err := n.call([]byte{0, 0}, 12)
+	if err != nil {
+		return nil, err
+	}
+	return net.IP(resp[8:12]), nil
+}
+
+func (n *natPMP) add(external, local int, lifetime time.Duration) (int, error) {
+	req := make([]byte, 12)
+	req[1] = 2 // tcp
+	binary.BigEndian.PutUint16(req[4:], uint16(local))
+	binary.BigEndian.PutUint16(req[6:], uint16(external))
+	binary.BigEndian.PutUint32(req[8:], uint32(lifetime/time.Second))
+	resp, err := n.call(req, 16)
+	if err != nil {
+		return 0, err
+	}
+	return int(binary.BigEndian.Uint16(resp[10:12])), nil
+}
+
+func (n *natPMP) remove(external, local int) error {
+	// A zero lifetime and external port delete the mapping.
+	req := make([]byte, 12)
+	req[1] = 2
+	binary.BigEndian.PutUint16(req[4:], uint16(local))
+	_, err := n.call(req, 16)
+	return err
+}
+
+// igd maps ports with the WAN connection service of a UPnP internet gateway
+// device.
+type igd struct {
+	control     string
+	serviceType string
+	localIP     net.IP
+}
+
+func (g *igd) String() string {
+	return "upnp " + g.control
+}
+
+// discoverIGD searches for a gateway via SSDP and reads its description for
+// the control URL of the WAN connection.
+func discoverIGD(ctx context.Context) (*igd, error) {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	for _, st := range []string{
+		"urn:schemas-upnp-org:device:InternetGatewayDevice:1",
+		"urn:schemas-upnp-org:device:InternetGatewayDevice:2",
+	} {
+		msg := "M-SEARCH * HTTP/1.1\r\nHOST: 239.255.255.250:1900\r\nMAN: \"ssdp:discover\"\r\nMX: 2\r\nST: " + st + "\r\n\r\n"
+		if _, err := conn.WriteTo([]byte(msg), ssdpGroup); err != nil {
+			return nil, err
+		}
+	}
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	buf := make([]byte, 2048)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				return nil, errors.New("no internet gateway device found")
+			}
+			return nil, err
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+		loc, err := url.Parse(resp.Header.Get("Location"))
+		if err != nil || loc.Host == "" {
+			continue
+		}
+		if g, err := readIGD(ctx, loc); err == nil {
+			return g, nil
+		} else {
+			log.Printf("upnp: %s: %v", loc, err)
+		}
+	}
+}
+
+// readIGD reads the device description at loc.
+func readIGD(ctx context.Context, loc *url.URL) (*igd, error) {
+	type service struct {
+		ServiceType string `xml:"serviceType"`
+		ControlURL  string `xml:"controlURL"`
+	}
+	type device struct {
+		Services []service `xml:"serviceList>service"`
+		Devices  []device  `xml:"deviceList>device"`
+	}
+	var desc struct {
+		URLBase string `xml:"URLBase"`
+		Device  device `xml:"device"`
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, loc.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&desc); err != nil {
+		return nil, err
+	}
+	base := loc
+	if desc.URLBase != "" {
+		if u, err := url.Parse(desc.URLBase); err == nil {
+			base = u
+		}
+	}
+	// The local address facing the router is the one to forward to.
+	conn, err := net.Dial("udp4", loc.Host)
+	if err != nil {
+		return nil, err
+	}
+	localIP := conn.LocalAddr().(*net.UDPAddr).IP
+	conn.Close()
+	var find func(d device) *igd
+	find = func(d device) *igd {
+		for _, s := range d.Services {
+			if strings.HasPrefix(s.ServiceType, "urn:schemas-upnp-org:service:WANIPConnection:") ||
+				strings.HasPrefix(s.ServiceType, "urn:schemas-upnp-org:service:WANPPPConnection:") {
+				if u, err := base.Parse(s.ControlURL); err == nil {
+					return &igd{control: u.String(), serviceType: s.ServiceType, localIP: localIP}
+				}
+			}
+		}
+		for _, sub := range d.Devices {
+			if g := find(sub); g != nil {
+				return g
+			}
+		}
+		return nil
+	}
+	if g := find(desc.Device); g != nil {
+		return g, nil
+	}
+	return nil, errors.New("no WAN connection service")
+}
+
+// soapError is a UPnP error returned by a gateway.
+type soapError struct {
+	code        int
+	description string
+}
+
+func (e *soapError) Error() string {
+	return fmt.Sprintf("upnp error %d: %s", e.code, e.description)
+}
+
+// call invokes action with args, given as name and value pairs, and returns
+// the response arguments.
+func (g *igd) call(action string, args ...string) (map[string]string, error) {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>`)
+	fmt.Fprintf(&b, `<u:%s xmlns:u="%s">`, action, g.serviceType)
+	for i := 0; i+1 < len(args); i += 2 {
+		b.WriteString("<" + args[i] + ">")
+		xml.EscapeText(&b, []byte(args[i+1]))
+		b.WriteString("</" + args[i] + ">")
+	}
+	fmt.Fprintf(&b, `</u:%s></s:Body></s:Envelope>`, action)
+	req, err := http.NewRequest(http.MethodPost, g.control, strings.NewReader(b.String()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", `"`+g.serviceType+"#"+action+`"`)
+	client := http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	// The response arguments and error details are the leaf elements of the
+	// body, whatever their namespaces.
+	values := map[string]string{}
+	d := xml.NewDecoder(io.LimitReader(resp.Body, 1<<20))
+	var name, text string
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name, text = t.Name.Local, ""
+		case xml.CharData:
+			text += string(t)
+		case xml.EndElement:
+			if t.Name.Local == name {
+				values[name] = strings.TrimSpace(text)
+			}
+			name = ""
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		code, err := strconv.Atoi(values["errorCode"])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", action, resp.Status)
+		}
+		return nil, &soapError{code: code, description: values["errorDescription"]}
+	}
+	return values, nil
+}
+
+func (g *igd) externalIP() (net.IP, error) {
+	values, err := g.call("GetExternalIPAddress")
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(values["NewExternalIPAddress"])
+	if ip == nil {
+		return nil, fmt.Errorf("invalid external address %q", values["NewExternalIPAddress"])
+	}
+	return ip, nil
+}
+
+func (g *igd) add(external, local int, lifetime time.Duration) (int, error) {
+	mapping := func(lease int) error {
+		_, err := g.call("AddPortMapping",
+			"NewRemoteHost", "",
+			"NewExternalPort", strconv.Itoa(external),
+			"NewProtocol", "TCP",
+			"NewInternalPort", strconv.Itoa(local),
+			"NewInternalClient", g.localIP.String(),
+			"NewEnabled", "1",
+			"NewPortMappingDescription", "webshare",
+			"NewLeaseDuration", strconv.Itoa(lease))
+		return err
+	}
+	err := mapping(int(lifetime / time.Second))
+	// Some routers only support permanent forwardings, which are then
+	// only removed on exit.
+	var se *soapError
+	if errors.As(err, &se) && se.code == 725 {
+		err = mapping(0)
+	}
+	return external, err
+}
+
+func (g *igd) remove(external, local int) error {
+	_, err := g.call("DeletePortMapping",
+		"NewRemoteHost", "",
+		"NewExternalPort", strconv.Itoa(external),
+		"NewProtocol", "TCP")
+	return err
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// serveFTP runs a minimal passive-mode ftp server for dir on ln until ctx
+// is done. Any user name and password is accepted; writes are only allowed
+// if writable is set.
+func serveFTP(ctx context.Context, ln net.Listener, dir string, writable bool) error {
+	log.Printf("ftp listening on %s", ln.Addr())
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if !clientAllowed(conn.RemoteAddr()) {
+			log.Printf("ftp %s: denied access", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+		s := &ftpSession{conn: conn, dir: dir, writable: writable, cwd: "/"}
+		go s.serve()
+	}
+}
+
+// ftpSession is the state of a single ftp control connection.
+type ftpSession struct {
+	conn       net.Conn
+	w          *bufio.Writer
+	dir        string
+	writable   bool
+	cwd        string
+	pasv       net.Listener
+	renameFrom string
+}
+
+func (s *ftpSession) reply(code int, format string, args ...interface{}) {
+	fmt.Fprintf(s.w, "%d %s\r\n", code, fmt.Sprintf(format, args...))
+	s.w.Flush()
+}
+
+// abs returns the slash separated path of p relative to the current
+// working directory, never leaving the share.
+func (s *ftpSession) abs(p string) string {
+	if !strings.HasPrefix(p, "/") {
+		p = path.Join(s.cwd, p)
+	}
+	return path.Clean("/" + p)
+}
+
+func (s *ftpSession) serve() {
+	defer s.conn.Close()
+	defer func() {
+		if s.pasv != nil {
+			s.pasv.Close()
+		}
+	}()
+	remote := s.conn.RemoteAddr().String()
+	log.Println(remote, "ftp connect")
+	s.w = bufio.NewWriter(s.conn)
+	s.reply(220, "webshare ftp ready")
+	sc := bufio.NewScanner(s.conn)
+	for sc.Scan() {
+		cmd, arg, _ := strings.Cut(strings.TrimRight(sc.Text(), "\r"), " ")
+		cmd = strings.ToUpper(cmd)
+		switch cmd {
+		case "USER":
+			s.reply(331, "any password will do")
+		case "PASS":
+			s.reply(230, "logged in")
+		case "SYST":
+			s.reply(215, "UNIX Type: L8")
+		case "FEAT":
+			fmt.Fprintf(s.w, "211-Features:\r\n EPSV\r\n PASV\r\n SIZE\r\n MDTM\r\n UTF8\r\n")
+			s.reply(211, "End")
+		case "OPTS":
+			s.reply(200, "ok")
+		case "NOOP":
+			s.reply(200, "ok")
+		case "TYPE", "MODE", "STRU":
+			s.reply(200, "ok")
+		case "PWD", "XPWD":
+			s.reply(257, "%q", s.cwd)
+		case "CWD", "XCWD", "CDUP":
+			if cmd == "CDUP" {
+				arg = ".."
+			}
+			p := s.abs(arg)
+			if fi, err := policy.stat(s.dir, p); err != nil || !fi.IsDir() {
+				s.reply(550, "no such directory")
+				continue
+			}
+			s.cwd = p
+			s.reply(250, "ok")
+		case "PASV", "EPSV":
+			s.passive(cmd)
+		case "LIST", "NLST":
+			// Skip ls style options like -la that some clients send
+			// before the path.
+			for strings.HasPrefix(arg, "-") {
+				_, arg, _ = strings.Cut(arg, " ")
+				arg = strings.TrimLeft(arg, " ")
+			}
+			s.list(s.abs(arg), cmd == "NLST")
+		case "SIZE":
+			fi, err := policy.stat(s.dir, s.abs(arg))
+			if err != nil || fi.IsDir() {
+				s.reply(550, "no such file")
+				continue
+			}
+			s.reply(213, "%d", fi.Size())
+		case "MDTM":
+			fi, err := policy.stat(s.dir, s.abs(arg))
+			if err != nil {
+				s.reply(550, "no such file")
+				continue
+			}
+			s.reply(213, "%s", fi.ModTime().UTC().Format("20060102150405"))
+		case "RETR":
+			log.Println(remote, "ftp get", s.abs(arg))
+			s.retrieve(s.abs(arg))
+		case "STOR", "MKD", "XMKD", "DELE", "RMD", "XRMD", "RNFR", "RNTO":
+			if !s.writable {
+				s.reply(550, "read-only share")
+				continue
+			}
+			log.Println(remote, "ftp", strings.ToLower(cmd), s.abs(arg))
+			s.modify(cmd, s.abs(arg))
+		case "QUIT":
+			s.reply(221, "bye")
+			return
+		default:
+			s.reply(502, "command not implemented")
+		}
+	}
+}
+
+// passive opens a data listener on the address the client connected to.
+func (s *ftpSession) passive(cmd string) {
+	if s.pasv != nil {
+		s.pasv.Close()
+	}
+	host, _, _ := net.SplitHostPort(s.conn.LocalAddr().String())
+	ln, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		s.reply(425, "cannot open data connection")
+		return
+	}
+	s.pasv = ln
+	port := ln.Addr().(*net.TCPAddr).Port
+	if cmd == "EPSV" {
+		s.reply(229, "Entering Extended Passive Mode (|||%d|)", port)
+		return
+	}
+	ip := net.ParseIP(host).To4()
+	if ip == nil {
+		s.reply(425, "use EPSV for ipv6")
+		return
+	}
+	s.reply(227, "Entering Passive Mode (%d,%d,%d,%d,%d,%d)", ip[0], ip[1], ip[2], ip[3], port>>8, port&0xff)
+}
+
+// data accepts the pending passive data connection, from the address of
+// the control connection only, so nobody else can take the transfer.
+func (s *ftpSession) data() (net.Conn, error) {
+	if s.pasv == nil {
+		return nil, fmt.Errorf("use PASV or EPSV first")
+	}
+	defer func() {
+		s.pasv.Close()
+		s.pasv = nil
+	}()
+	if l, ok := s.pasv.(*net.TCPListener); ok {
+		l.SetDeadline(time.Now().Add(30 * time.Second))
+	}
+	want, _ := s.conn.RemoteAddr().(*net.TCPAddr)
+	for {
+		conn, err := s.pasv.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok && want != nil && addr.IP.Equal(want.IP) {
+			return conn, nil
+		}
+		log.Printf("ftp %s: data connection from %s refused", s.conn.RemoteAddr(), conn.RemoteAddr())
+		conn.Close()
+	}
+}
+
+func (s *ftpSession) list(p string, namesOnly bool) {
+	fn := localPath(s.dir, p)
+	fi, err := policy.stat(s.dir, p)
+	if err != nil {
+		s.reply(550, "no such file or directory")
+		return
+	}
+	infos := []os.FileInfo{fi}
+	if fi.IsDir() {
+		entries, err := os.ReadDir(fn)
+		if err != nil {
+			s.reply(550, "cannot read directory")
+			return
+		}
+		infos = infos[:0]
+		for _, e := range entries {
+			if !policy.listedEntry(s.dir, path.Join(p, e.Name()), e) {
+				continue
+			}
+			if info, err := e.Info(); err == nil {
+				infos = append(infos, info)
+			}
+		}
+	}
+	s.reply(150, "here comes the listing")
+	conn, err := s.data()
+	if err != nil {
+		s.reply(425, "%v", err)
+		return
+	}
+	w := bufio.NewWriter(conn)
+	for _, info := range infos {
+		if namesOnly {
+			fmt.Fprintf(w, "%s\r\n", info.Name())
+			continue
+		}
+		fmt.Fprintf(w, "%s 1 ftp ftp %12d %s %s\r\n",
+			info.Mode().String(), info.Size(), info.ModTime().Format("Jan _2 15:04"), info.Name())
+	}
+	w.Flush()
+	conn.Close()
+	s.reply(226, "done")
+}
+
+func (s *ftpSession) retrieve(p string) {
+	if _, err := policy.stat(s.dir, p); err != nil {
+		s.reply(550, "no such file")
+		return
+	}
+	f, err := os.Open(localPath(s.dir, p))
+	if err != nil {
+		s.reply(550, "no such file")
+		return
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err != nil || fi.IsDir() {
+		s.reply(550, "not a file")
+		return
+	}
+	s.reply(150, "opening data connection")
+	conn, err := s.data()
+	if err != nil {
+		s.reply(425, "%v", err)
+		return
+	}
+	_, err = io.Copy(conn, f)
+	conn.Close()
+	if err != nil {
+		s.reply(426, "transfer aborted")
+		return
+	}
+	s.reply(226, "done")
+}
+
+func (s *ftpSession) modify(cmd, p string) {
+	fn, err := resolvePath(s.dir, p)
+	if err != nil {
+		s.reply(550, "%v", err)
+		return
+	}
+	switch cmd {
+	case "STOR":
+		s.reply(150, "ready to receive")
+		conn, err := s.data()
+		if err != nil {
+			s.reply(425, "%v", err)
+			return
+		}
+		_, err = writeFile(fn, conn)
+		conn.Close()
+		if err != nil {
+			s.reply(451, "%v", err)
+			return
+		}
+		s.reply(226, "done")
+		return
+	case "MKD", "XMKD":
+		if err := os.Mkdir(fn, 0755); err != nil {
+			s.reply(550, "%v", err)
+			return
+		}
+		s.reply(257, "%q created", p)
+		return
+	case "DELE", "RMD", "XRMD":
+		err = os.Remove(fn)
+	case "RNFR":
+		s.renameFrom = fn
+		s.reply(350, "ready for RNTO")
+		return
+	case "RNTO":
+		if s.renameFrom == "" {
+			s.reply(503, "use RNFR first")
+			return
+		}
+		err = os.Rename(s.renameFrom, fn)
+		s.renameFrom = ""
+	}
+	if err != nil {
+		s.reply(550, "%v", err)
+		return
+	}
+	s.reply(250, "ok")
+}
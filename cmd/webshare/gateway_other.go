@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// defaultGateway is only implemented on linux, elsewhere routers are only
+// found via UPnP.
+func defaultGateway() (net.IP, error) {
+	return nil, errors.New("finding the default gateway is only supported on linux")
+}
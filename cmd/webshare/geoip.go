@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"os"
+)
+
+// geoDB looks up the location of addresses in a MaxMind DB file like
+// GeoLite2-City.mmdb, see https://maxmind.github.io/MaxMind-DB/.
+type geoDB struct {
+	tree       []byte
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	// ipv4Start is the node for ::/96, where IPv4 addresses start in an
+	// IPv6 tree.
+	ipv4Start uint
+	ipVersion uint
+}
+
+// geo is set with -geoip.
+var geo *geoDB
+
+var mmdbMetadataStart = []byte("\xab\xcd\xefMaxMind.com")
+
+// openGeoDB reads the database in fn into memory.
+func openGeoDB(fn string) (*geoDB, error) {
+	b, err := os.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	i := bytes.LastIndex(b, mmdbMetadataStart)
+	if i < 0 {
+		return nil, fmt.Errorf("%s: not a MaxMind DB file", fn)
+	}
+	meta := b[i+len(mmdbMetadataStart):]
+	v, _, err := mmdbDecode(meta, 0)
+	if err != nil {
+		return nil, fmt.Errorf("%s: metadata: %w", fn, err)
+	}
+	m, _ := v.(map[string]any)
+	nodeCount, _ := m["node_count"].(uint64)
+	recordSize, _ := m["record_size"].(uint64)
+	ipVersion, _ := m["ip_version"].(uint64)
+	if recordSize != 24 && recordSize != 28 && recordSize != 32 {
+		return nil, fmt.Errorf("%s: unsupported record size %d", fn, recordSize)
+	}
+	treeSize := nodeCount * recordSize / 4
+	// The tree and data are separated by 16 zero bytes.
+	if treeSize+16 > uint64(i) {
+		return nil, fmt.Errorf("%s: truncated", fn)
+	}
+	db := &geoDB{
+		tree:       b[:treeSize],
+		data:       b[treeSize+16 : i],
+		nodeCount:  uint(nodeCount),
+		recordSize: uint(recordSize),
+		ipVersion:  uint(ipVersion),
+	}
+	if db.ipVersion == 6 {
+		node := uint(0)
+		for range 96 {
+			if node >= db.nodeCount {
+				break
+			}
+			node = db.record(node, 0)
+		}
+		db.ipv4Start = node
+	}
+	return db, nil
+}
+
+// record returns the left (bit 0) or right (bit 1) record of node.
+func (db *geoDB) record(node, bit uint) uint {
+	switch db.recordSize {
+	case 24:
+		b := db.tree[node*6+bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		b := db.tree[node*7:]
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(db.tree[node*8+bit*4:]))
+	}
+}
+
+// lookup returns the record of ip, or nil if there is none.
+func (db *geoDB) lookup(ip net.IP) (any, error) {
+	node := uint(0)
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		if db.ipVersion == 6 {
+			node = db.ipv4Start
+		}
+	} else if db.ipVersion == 4 {
+		return nil, nil
+	}
+	for i := 0; i < len(ip)*8 && node < db.nodeCount; i++ {
+		bit := uint(ip[i/8]>>(7-i%8)) & 1
+		node = db.record(node, bit)
+	}
+	if node <= db.nodeCount {
+		return nil, nil
+	}
+	v, _, err := mmdbDecode(db.data, int(node-db.nodeCount-16))
+	return v, err
+}
+
+// locate returns the city and country code of ip, like "Berlin, DE", or
+// less if the database does not know it.
+func (db *geoDB) locate(ip net.IP) string {
+	v, err := db.lookup(ip)
+	if err != nil || v == nil {
+		return ""
+	}
+	rec, _ := v.(map[string]any)
+	country, _ := mmdbPath(rec, "country", "iso_code").(string)
+	city, _ := mmdbPath(rec, "city", "names", "en").(string)
+	switch {
+	case city != "" && country != "":
+		return city + ", " + country
+	case country != "":
+		return country
+	}
+	return city
+}
+
+// mmdbPath follows the keys into nested maps.
+func mmdbPath(v any, keys ...string) any {
+	for _, k := range keys {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil
+		}
+		v = m[k]
+	}
+	return v
+}
+
+// clientLocation returns the location of the client of r with -geoip, or
+// an empty string for private addresses.
+func clientLocation(r *http.Request) string {
+	if geo == nil {
+		return ""
+	}
+	ip := net.ParseIP(remoteHost(r))
+	if ip == nil || isPrivateIP(ip) {
+		return ""
+	}
+	return geo.locate(ip)
+}
+
+var errMMDBData = errors.New("invalid MaxMind DB data")
+
+// mmdbDecode decodes the value at offset in the data section b and returns
+// it with the offset after it. Maps become map[string]any, unsigned
+// integers uint64.
+func mmdbDecode(b []byte, offset int) (any, int, error) {
+	if offset < 0 || offset >= len(b) {
+		return nil, 0, errMMDBData
+	}
+	ctrl := b[offset]
+	offset++
+	typ := int(ctrl >> 5)
+	if typ == 1 {
+		// Pointers hold the offset of the value in the size bits.
+		n := int(ctrl>>3&3) + 1
+		if offset+n > len(b) {
+			return nil, 0, errMMDBData
+		}
+		p := 0
+		if n < 4 {
+			p = int(ctrl & 7)
+		}
+		for _, c := range b[offset : offset+n] {
+			p = p<<8 | int(c)
+		}
+		p += [...]int{0, 2048, 526336, 0}[n-1]
+		v, _, err := mmdbDecode(b, p)
+		return v, offset + n, err
+	}
+	if typ == 0 {
+		if offset >= len(b) {
+			return nil, 0, errMMDBData
+		}
+		typ = 7 + int(b[offset])
+		offset++
+	}
+	size := int(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > len(b) {
+			return nil, 0, errMMDBData
+		}
+		extra := 0
+		for _, c := range b[offset : offset+n] {
+			extra = extra<<8 | int(c)
+		}
+		offset += n
+		size = [...]int{29, 285, 65821}[n-1] + extra
+	}
+	switch typ {
+	case 7: // map
+		m := make(map[string]any, size)
+		for range size {
+			k, next, err := mmdbDecode(b, offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, errMMDBData
+			}
+			if m[key], offset, err = mmdbDecode(b, next); err != nil {
+				return nil, 0, err
+			}
+		}
+		return m, offset, nil
+	case 11: // array
+		a := make([]any, size)
+		for i := range a {
+			var err error
+			if a[i], offset, err = mmdbDecode(b, offset); err != nil {
+				return nil, 0, err
+			}
+		}
+		return a, offset, nil
+	case 14: // boolean, the value is the size
+		return size != 0, offset, nil
+	}
+	if offset+size > len(b) {
+		return nil, 0, errMMDBData
+	}
+	raw := b[offset : offset+size]
+	offset += size
+	switch typ {
+	case 2: // utf-8 string
+		return string(raw), offset, nil
+	case 3: // double
+		if size != 8 {
+			return nil, 0, errMMDBData
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(raw)), offset, nil
+	case 15: // float
+		if size != 4 {
+			return nil, 0, errMMDBData
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(raw))), offset, nil
+	case 4, 10: // bytes, uint128
+		return raw, offset, nil
+	case 5, 6, 9: // uint16, uint32, uint64
+		var n uint64
+		for _, c := range raw {
+			n = n<<8 | uint64(c)
+		}
+		return n, offset, nil
+	case 8: // int32
+		var n uint32
+		for _, c := range raw {
+			n = n<<8 | uint32(c)
+		}
+		return int64(int32(n)), offset, nil
+	}
+	return nil, 0, fmt.Errorf("%w: type %d", errMMDBData, typ)
+}
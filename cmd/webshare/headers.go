@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+// secureHeaders are set by -secure-headers. The listing pages use inline
+// styles and scripts, and the pdf viewer embeds files from the share.
+var secureHeaders = []header{
+	{"Content-Security-Policy", "default-src 'self'; script-src 'self' 'unsafe-inline'; style-src 'self' 'unsafe-inline'; img-src 'self' data: blob:; object-src 'self'; frame-ancestors 'self'; base-uri 'none'; form-action 'self'"},
+	{"X-Content-Type-Options", "nosniff"},
+	{"X-Frame-Options", "SAMEORIGIN"},
+	{"Referrer-Policy", "no-referrer"},
+	{"Cross-Origin-Opener-Policy", "same-origin"},
+}
+
+type header struct {
+	name, value string
+}
+
+// headerFlag collects repeated -header "Name: value" flags. An empty value
+// removes the header from the -secure-headers preset.
+type headerFlag []header
+
+func (f *headerFlag) String() string {
+	var parts []string
+	for _, h := range *f {
+		parts = append(parts, h.name+": "+h.value)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (f *headerFlag) Set(v string) error {
+	name, value, ok := strings.Cut(v, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" || strings.ContainsAny(name, " \t") {
+		return fmt.Errorf("invalid header %q, want Name: value", v)
+	}
+	*f = append(*f, header{textproto.CanonicalMIMEHeaderKey(name), strings.TrimSpace(value)})
+	return nil
+}
+
+// responseHeaders combines the preset, if secure is set, with the overrides.
+func responseHeaders(secure bool, overrides headerFlag) http.Header {
+	hdr := http.Header{}
+	if secure {
+		for _, h := range secureHeaders {
+			hdr.Set(h.name, h.value)
+		}
+	}
+	for _, h := range overrides {
+		if h.value == "" {
+			hdr.Del(h.name)
+		} else {
+			hdr.Set(h.name, h.value)
+		}
+	}
+	return hdr
+}
+
+// headerHandler wraps h and adds hdr to every response.
+func headerHandler(hdr http.Header, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for k, v := range hdr {
+			w.Header()[k] = v
+		}
+		h.ServeHTTP(w, r)
+	})
+}
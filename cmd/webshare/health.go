@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	healthzPath = "/_/healthz"
+	readyzPath  = "/_/readyz"
+)
+
+// redactedFlags hold secrets, which the health status leaves out.
+var redactedFlags = map[string]bool{
+	"cert-password": true,
+	"wifi-pass":     true,
+	"webhook":       true,
+}
+
+// healthCheck answers the liveness and readiness probes of container
+// orchestrators and load balancers.
+type healthCheck struct {
+	started time.Time
+	// ctx is done once the share shuts down.
+	ctx context.Context
+	// dirs are the shared directories.
+	dirs []string
+	// open is set with -from, -until and -days.
+	open *window
+	// token is the -admin token, which the probes take to also show the
+	// flags and the shared directories.
+	token string
+}
+
+// healthStatus is the answer of both probes.
+type healthStatus struct {
+	Status string  `json:"status"`
+	Uptime float64 `json:"uptime"`
+	// Config holds the flags that were set, from the command line, the
+	// environment or the config file, only shown with the admin token.
+	Config map[string]string `json:"config,omitempty"`
+	Disks  []diskStatus      `json:"disks,omitempty"`
+	// Problems say why the share is not ready.
+	Problems []string `json:"problems,omitempty"`
+}
+
+// diskStatus describes the file system of a shared directory, with its
+// path only shown with the admin token.
+type diskStatus struct {
+	Path  string `json:"path,omitempty"`
+	Free  uint64 `json:"free"`
+	Total uint64 `json:"total"`
+	Error string `json:"error,omitempty"`
+}
+
+func newHealthCheck(ctx context.Context, dirs []string, open *window, token string) *healthCheck {
+	return &healthCheck{started: time.Now(), ctx: ctx, dirs: dirs, open: open, token: token}
+}
+
+// status checks the share, which is not ready while shutting down, outside
+// of the -from and -until window or if a shared directory is gone. Only
+// full adds the flags and the paths, which may hold secrets.
+func (hc *healthCheck) status(full bool) healthStatus {
+	s := healthStatus{
+		Status: "ok",
+		Uptime: time.Since(hc.started).Seconds(),
+	}
+	if full {
+		s.Config = make(map[string]string)
+		flag.Visit(func(f *flag.Flag) {
+			if redactedFlags[f.Name] {
+				s.Config[f.Name] = "redacted"
+			} else {
+				s.Config[f.Name] = f.Value.String()
+			}
+		})
+	}
+	if hc.ctx.Err() != nil {
+		s.Problems = append(s.Problems, "shutting down")
+	}
+	if now := time.Now(); hc.open != nil && !hc.open.open(now) {
+		s.Problems = append(s.Problems, "closed until "+hc.open.next(now).Format("Mon 15:04"))
+	}
+	for i, dir := range hc.dirs {
+		d := diskStatus{}
+		name := fmt.Sprintf("shared directory %d", i+1)
+		if full {
+			d.Path, name = dir, dir
+		}
+		if fi, err := os.Stat(dir); err != nil {
+			// Without the path, which is in the status already.
+			d.Error = errors.Unwrap(err).Error()
+		} else if !fi.IsDir() {
+			d.Error = "not a directory"
+		} else if d.Free, d.Total, err = diskSpace(dir); err != nil && !errors.Is(err, errors.ErrUnsupported) {
+			d.Error = err.Error()
+		}
+		if d.Error != "" {
+			s.Problems = append(s.Problems, name+": "+d.Error)
+		}
+		s.Disks = append(s.Disks, d)
+	}
+	return s
+}
+
+// Handler wraps h and answers /_/healthz as long as the server runs and
+// /_/readyz with 503 while the share is not ready. The probes work without
+// the token, the code and the client certificate, so they only show the
+// flags and paths for the admin token.
+func (hc *healthCheck) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != healthzPath && r.URL.Path != readyzPath {
+			h.ServeHTTP(w, r)
+			return
+		}
+		s := hc.status(hc.token != "" && bearerOK(r, hc.token))
+		w.Header().Set("Cache-Control", "no-store")
+		if r.URL.Path == readyzPath && len(s.Problems) > 0 {
+			s.Status = "unavailable"
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		writeJSON(w, s)
+	})
+}
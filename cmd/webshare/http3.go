@@ -0,0 +1,27 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// newHTTP3Server returns a server for h over QUIC, with the TLS settings of
+// the https server.
+func newHTTP3Server(port int, tlsConfig *tls.Config, h http.Handler) *http3.Server {
+	return &http3.Server{
+		Port:      port,
+		TLSConfig: http3.ConfigureTLSConfig(tlsConfig),
+		Handler:   h,
+	}
+}
+
+// altSvcHandler wraps h and advertises s in every response, so browsers
+// switch to HTTP/3 for their next requests.
+func altSvcHandler(s *http3.Server, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.SetQUICHeaders(w.Header())
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// idleWatch calls idle once no request was in progress for a while, for
+// -idle-timeout. Long downloads keep the share busy until they end.
+type idleWatch struct {
+	timeout time.Duration
+
+	mu     sync.Mutex
+	active int
+	timer  *time.Timer
+}
+
+func newIdleWatch(timeout time.Duration, idle func()) *idleWatch {
+	return &idleWatch{
+		timeout: timeout,
+		timer: time.AfterFunc(timeout, func() {
+			log.Printf("no requests for %s, shutting down", timeout)
+			idle()
+		}),
+	}
+}
+
+// Handler wraps h and holds off the timeout while requests are served.
+func (iw *idleWatch) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		iw.mu.Lock()
+		iw.active++
+		iw.timer.Stop()
+		iw.mu.Unlock()
+		defer func() {
+			iw.mu.Lock()
+			defer iw.mu.Unlock()
+			if iw.active--; iw.active == 0 {
+				iw.timer.Reset(iw.timeout)
+			}
+		}()
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,60 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+)
+
+// jailEnv is set for the copy of webshare started in a user namespace.
+const jailEnv = "WEBSHARE_JAILED"
+
+// enterJail confines the process to dir with chroot(2), so no handler can
+// reach files outside of it, whatever path it gets. Without root privileges
+// webshare first restarts itself in a new user namespace, where chroot is
+// permitted; in that case enterJail only returns on error and the process
+// exits with the status of the jailed copy.
+func enterJail(dir string) error {
+	if os.Geteuid() != 0 && os.Getenv(jailEnv) == "" {
+		return rerunInUserNamespace()
+	}
+	if err := syscall.Chroot(dir); err != nil {
+		return fmt.Errorf("jail: chroot %s: %w", dir, err)
+	}
+	return os.Chdir("/")
+}
+
+// rerunInUserNamespace runs webshare again with the same arguments as root
+// of a new user namespace, mapped to the current user, and exits with its
+// status.
+func rerunInUserNamespace() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("jail: %w", err)
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(), jailEnv+"=1")
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags:  syscall.CLONE_NEWUSER,
+		UidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}},
+		GidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}},
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("jail: cannot create user namespace: %w", err)
+	}
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		for sig := range sigChan {
+			cmd.Process.Signal(sig)
+		}
+	}()
+	cmd.Wait()
+	os.Exit(cmd.ProcessState.ExitCode())
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// enterJail is only implemented on linux.
+func enterJail(dir string) error {
+	return errors.New("-jail is only supported on linux")
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// downloadLimiter counts completed downloads, globally and per path. Once
+// the global limit is reached it either calls exhausted, which shuts the
+// server down, or answers every further request with 410 Gone. Files that
+// reached their own limit are always gone.
+type downloadLimiter struct {
+	maxTotal   int
+	maxPerFile int
+	gone       bool
+	exhausted  func()
+
+	mu      sync.Mutex
+	total   int
+	perFile map[string]int
+	// pending counts the downloads in progress, which hold a slot until
+	// they complete or fail, so concurrent ones cannot exceed the limits.
+	pending        int
+	pendingPerFile map[string]int
+}
+
+func (l *downloadLimiter) allowed(p string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.gone && l.maxTotal > 0 && l.total >= l.maxTotal {
+		return false
+	}
+	return l.maxPerFile == 0 || l.perFile[p] < l.maxPerFile
+}
+
+// reserve takes a slot for a download of p, if one is left.
+func (l *downloadLimiter) reserve(p string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.maxTotal > 0 && l.total+l.pending >= l.maxTotal {
+		return false
+	}
+	if l.maxPerFile > 0 && l.perFile[p]+l.pendingPerFile[p] >= l.maxPerFile {
+		return false
+	}
+	if l.pendingPerFile == nil {
+		l.pendingPerFile = make(map[string]int)
+	}
+	l.pending++
+	l.pendingPerFile[p]++
+	return true
+}
+
+// release frees the slot of a download of p.
+func (l *downloadLimiter) release(p string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.releaseLocked(p)
+}
+
+func (l *downloadLimiter) releaseLocked(p string) {
+	l.pending--
+	if l.pendingPerFile[p]--; l.pendingPerFile[p] == 0 {
+		delete(l.pendingPerFile, p)
+	}
+}
+
+// count turns the slot of a download of p into a completed download.
+func (l *downloadLimiter) count(r *http.Request, p string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.releaseLocked(p)
+	if l.perFile == nil {
+		l.perFile = make(map[string]int)
+	}
+	l.total++
+	l.perFile[p]++
+	logRequest(r, "%s downloaded %s (%d total, %d of this file)", r.RemoteAddr, p, l.total, l.perFile[p])
+	if l.maxTotal == 0 || l.total != l.maxTotal {
+		return
+	}
+	if l.gone {
+		log.Printf("download limit of %d reached, answering with 410 Gone", l.maxTotal)
+		return
+	}
+	log.Printf("download limit of %d reached, shutting down", l.maxTotal)
+	l.exhausted()
+}
+
+// Handler wraps h with the download accounting.
+func (l *downloadLimiter) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := path.Clean("/" + r.URL.Path)
+		if !l.allowed(p) {
+			http.Error(w, "download limit reached", http.StatusGone)
+			return
+		}
+		sw := &slotWriter{ResponseWriter: w, l: l, r: r, p: p}
+		rec := &responseRecorder{ResponseWriter: sw}
+		h.ServeHTTP(rec, r)
+		switch {
+		case !sw.reserved:
+		case rec.isDownload(r):
+			l.count(r, p)
+		default:
+			l.release(p)
+		}
+	})
+}
+
+var errDownloadLimit = errors.New("download limit reached")
+
+// slotWriter reserves a download slot once the response turns out to be a
+// download, by the same headers isDownload looks at, and answers with 410
+// Gone instead if there is none left.
+type slotWriter struct {
+	http.ResponseWriter
+	l           *downloadLimiter
+	r           *http.Request
+	p           string
+	wroteHeader bool
+	reserved    bool
+	refused     bool
+}
+
+func (sw *slotWriter) WriteHeader(code int) {
+	if sw.wroteHeader {
+		sw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	sw.wroteHeader = true
+	h := sw.Header()
+	download := h.Get("Content-Length") != "" || strings.HasPrefix(h.Get("Content-Disposition"), "attachment")
+	if code != http.StatusOK || sw.r.Method != http.MethodGet || !download || auxiliary(sw.r) {
+		sw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if sw.reserved = sw.l.reserve(sw.p); sw.reserved {
+		sw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	sw.refused = true
+	for _, k := range []string{"Content-Disposition", "Content-Encoding", "Content-Range", "Accept-Ranges", "ETag", "Last-Modified"} {
+		h.Del(k)
+	}
+	http.Error(sw.ResponseWriter, errDownloadLimit.Error(), http.StatusGone)
+}
+
+func (sw *slotWriter) Write(b []byte) (int, error) {
+	if !sw.wroteHeader {
+		sw.WriteHeader(http.StatusOK)
+	}
+	if sw.refused {
+		return 0, errDownloadLimit
+	}
+	return sw.ResponseWriter.Write(b)
+}
+
+func (sw *slotWriter) ReadFrom(r io.Reader) (int64, error) {
+	if !sw.wroteHeader {
+		sw.WriteHeader(http.StatusOK)
+	}
+	if sw.refused {
+		return 0, errDownloadLimit
+	}
+	if rf, ok := sw.ResponseWriter.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(sw.ResponseWriter, r)
+}
+
+func (sw *slotWriter) Flush() {
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (sw *slotWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// byteBudget caps the total number of response body bytes sent across all
+// requests. Writes beyond the budget fail, and exhausted is called once.
+type byteBudget struct {
+	max       int64
+	exhausted func()
+
+	mu   sync.Mutex
+	sent int64
+	once sync.Once
+}
+
+// reserve grants up to n bytes of the remaining budget.
+func (b *byteBudget) reserve(n int64) int64 {
+	b.mu.Lock()
+	n = min(n, b.max-b.sent)
+	b.sent += n
+	done := b.sent >= b.max
+	b.mu.Unlock()
+	if done {
+		b.once.Do(func() {
+			log.Printf("transfer budget of %s exhausted, shutting down", formatSize(b.max))
+			b.exhausted()
+		})
+	}
+	return n
+}
+
+func (b *byteBudget) release(n int64) {
+	b.mu.Lock()
+	b.sent -= n
+	b.mu.Unlock()
+}
+
+func (b *byteBudget) left() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.max - b.sent
+}
+
+// Handler wraps h so that response bodies are taken from the budget.
+func (b *byteBudget) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if b.left() <= 0 {
+			http.Error(w, "transfer budget exhausted", http.StatusGone)
+			return
+		}
+		h.ServeHTTP(&budgetWriter{ResponseWriter: w, budget: b}, r)
+	})
+}
+
+// budgetWriter is a ResponseWriter that stops writing when the budget is
+// used up.
+type budgetWriter struct {
+	http.ResponseWriter
+	budget *byteBudget
+}
+
+func (bw *budgetWriter) Write(p []byte) (int, error) {
+	granted := bw.budget.reserve(int64(len(p)))
+	n, err := bw.ResponseWriter.Write(p[:granted])
+	bw.budget.release(granted - int64(n))
+	if err == nil && n < len(p) {
+		err = errBudgetExhausted
+	}
+	return n, err
+}
+
+// ReadFrom copies in chunks, so concurrent transfers share the remaining
+// budget fairly, while keeping the sendfile path of the wrapped writer.
+func (bw *budgetWriter) ReadFrom(r io.Reader) (int64, error) {
+	var total int64
+	for {
+		granted := bw.budget.reserve(1 << 20)
+		if granted == 0 {
+			return total, errBudgetExhausted
+		}
+		n, err := readChunk(bw.ResponseWriter, r, granted)
+		total += n
+		bw.budget.release(granted - n)
+		if err != nil || n < granted {
+			return total, err
+		}
+	}
+}
+
+func (bw *budgetWriter) Flush() {
+	if f, ok := bw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (bw *budgetWriter) Unwrap() http.ResponseWriter {
+	return bw.ResponseWriter
+}
+
+var errBudgetExhausted = errors.New("transfer budget exhausted")
+
+// requestLimiter caps the number of requests served at the same time.
+// Requests over the cap wait up to queue for a free slot, and are answered
+// with 503 Service Unavailable if none becomes free.
+type requestLimiter struct {
+	slots chan struct{}
+	queue time.Duration
+}
+
+func newRequestLimiter(n int, queue time.Duration) *requestLimiter {
+	return &requestLimiter{slots: make(chan struct{}, n), queue: queue}
+}
+
+func (l *requestLimiter) acquire(r *http.Request) bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+	if l.queue <= 0 {
+		return false
+	}
+	t := time.NewTimer(l.queue)
+	defer t.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-t.C:
+	case <-r.Context().Done():
+	}
+	return false
+}
+
+// Handler wraps h with the request cap.
+func (l *requestLimiter) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !l.acquire(r) {
+			logRequest(r, "%s turned away, %d requests in progress", r.RemoteAddr, cap(l.slots))
+			w.Header().Set("Retry-After", "5")
+			http.Error(w, "too many connections, try again later", http.StatusServiceUnavailable)
+			return
+		}
+		defer func() { <-l.slots }()
+		h.ServeHTTP(w, r)
+	})
+}
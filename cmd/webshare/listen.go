@@ -0,0 +1,233 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/ipv4"
+)
+
+// listen opens a listener for addr, which is a tcp address or a unix socket
+// path prefixed with "unix:". A socket left behind by an earlier run is
+// replaced.
+func listen(addr string) (net.Listener, error) {
+	fn, ok := strings.CutPrefix(addr, "unix:")
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	if fi, err := os.Lstat(fn); err == nil && fi.Mode().Type() == fs.ModeSocket {
+		if c, err := net.Dial("unix", fn); err == nil {
+			c.Close()
+			return nil, fmt.Errorf("%s is in use", fn)
+		}
+		os.Remove(fn)
+	}
+	return net.Listen("unix", fn)
+}
+
+// listenFDsStart is the first file descriptor passed by systemd.
+const listenFDsStart = 3
+
+// systemdListeners returns the sockets passed by systemd socket activation,
+// if any. The environment variables are cleared, so child processes do not
+// take them for their own.
+func systemdListeners() ([]net.Listener, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	var lns []net.Listener
+	for i := 0; i < n; i++ {
+		fd := listenFDsStart + i
+		name := "fd " + strconv.Itoa(fd)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(fd), name)
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("systemd socket %s: %w", name, err)
+		}
+		lns = append(lns, ln)
+	}
+	return lns, nil
+}
+
+// listenFlag collects repeated -listen flags.
+type listenFlag []string
+
+func (f *listenFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *listenFlag) Set(v string) error {
+	if !isSocket(v) {
+		if _, p, err := net.SplitHostPort(v); err != nil {
+			return fmt.Errorf("invalid listen address %q, want host:port or unix:/path", v)
+		} else if _, err := strconv.Atoi(p); err != nil {
+			return fmt.Errorf("invalid port in listen address %q", v)
+		}
+	}
+	*f = append(*f, v)
+	return nil
+}
+
+// sockets reports whether all addresses are unix sockets.
+func (f listenFlag) sockets() bool {
+	for _, addr := range f {
+		if !isSocket(addr) {
+			return false
+		}
+	}
+	return len(f) > 0
+}
+
+func isSocket(addr string) bool {
+	return strings.HasPrefix(addr, "unix:")
+}
+
+// endpoint is an address the printed links point to. Explicit ones were
+// given on the command line, the others are interface addresses.
+type endpoint struct {
+	host     string
+	ip       net.IP
+	port     int
+	explicit bool
+}
+
+// endpoints returns what the links to a tcp listener on host and port point
+// to: the addresses of the interfaces in ifaces if host is empty or the
+// unspecified address, host itself otherwise. IPv6 addresses are only
+// included if the listener takes them, global ones before unique local
+// ones, and link-local ones last with the zone of their interface, since
+// they only work on the same link.
+func endpoints(host string, port int, ifaces []net.Interface) []endpoint {
+	ip := net.ParseIP(host)
+	if host != "" && (ip == nil || !ip.IsUnspecified()) {
+		return []endpoint{{host: host, ip: ip, port: port, explicit: true}}
+	}
+	v4only := ip != nil && ip.To4() != nil
+	var eps, v6, linkLocal []endpoint
+	for _, ifi := range ifaces {
+		if ifi.Flags&net.FlagUp == 0 {
+			continue
+		}
+		addrs, err := ifi.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			e := endpoint{host: ipnet.IP.String(), ip: ipnet.IP, port: port}
+			switch {
+			case ipnet.IP.To4() != nil:
+				eps = append(eps, e)
+			case v4only || ipnet.IP.IsLoopback():
+				// The IPv4 loopback address has a link already.
+			case ipnet.IP.IsLinkLocalUnicast():
+				e.host += "%" + ifi.Name
+				linkLocal = append(linkLocal, e)
+			default:
+				v6 = append(v6, e)
+			}
+		}
+	}
+	slices.SortStableFunc(v6, func(a, b endpoint) int {
+		return cmp.Compare(boolInt(isPrivateIP(a.ip)), boolInt(isPrivateIP(b.ip)))
+	})
+	return append(append(eps, v6...), linkLocal...)
+}
+
+// boolInt returns 1 for true and 0 for false, for sorting.
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// linkLocal reports whether the endpoint is an IPv6 address with a zone,
+// which phones scanning a qr code cannot reach.
+func (e endpoint) linkLocal() bool {
+	return strings.Contains(e.host, "%")
+}
+
+// hostnameEndpoints returns eps with the addresses replaced by name, once
+// per port.
+func hostnameEndpoints(eps []endpoint, name string) []endpoint {
+	var named []endpoint
+	for _, e := range eps {
+		if !slices.ContainsFunc(named, func(n endpoint) bool { return n.port == e.port }) {
+			named = append(named, endpoint{host: name, port: e.port})
+		}
+	}
+	return named
+}
+
+// advertisedHostname returns the name of this host for links, with .local
+// appended if it is not fully qualified, which mdns resolves.
+func advertisedHostname() (string, error) {
+	name, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+	name = strings.ToLower(name)
+	if !strings.Contains(name, ".") {
+		name += ".local"
+	}
+	return name, nil
+}
+
+// lanIPs returns the IPv4 addresses of eps other than loopback ones, which
+// the share is announced on.
+func lanIPs(eps []endpoint) []net.IP {
+	var ips []net.IP
+	for _, e := range eps {
+		if ip := e.ip.To4(); ip != nil && !ip.IsLoopback() {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// listenMulticast opens a socket for the IPv4 multicast group and joins it
+// on every interface that supports multicast, which are returned.
+func listenMulticast(group *net.UDPAddr) (*ipv4.PacketConn, []net.Interface, error) {
+	// ListenMulticastUDP allows sharing the port with a system service.
+	c, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		return nil, nil, err
+	}
+	p := ipv4.NewPacketConn(c)
+	var joined []net.Interface
+	ifaces, _ := net.Interfaces()
+	for _, ifi := range ifaces {
+		if ifi.Flags&net.FlagUp == 0 || ifi.Flags&net.FlagMulticast == 0 {
+			continue
+		}
+		// Joining fails on the interface the socket was joined on already.
+		p.JoinGroup(&ifi, group)
+		joined = append(joined, ifi)
+	}
+	p.SetControlMessage(ipv4.FlagInterface, true)
+	return p, joined, nil
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// listingHandler renders directory listings itself, in the same plain style
+// as http.FileServer, so that extra links can be added. Everything else,
+// including directories with an index.html, is passed on to h. Listings are
+// answered for any method, as http.FileServer does, so that its own listing,
+// which ignores the path policy, is never reached.
+func listingHandler(dir string, thumbs bool, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			h.ServeHTTP(w, r)
+			return
+		}
+		fn := localPath(dir, r.URL.Path)
+		if fi, err := os.Stat(fn); err != nil || !fi.IsDir() {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if _, err := os.Stat(filepath.Join(fn, "index.html")); err == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		entries, err := os.ReadDir(fn)
+		if err != nil {
+			http.Error(w, "Error reading directory", http.StatusInternalServerError)
+			return
+		}
+		var infos []fs.FileInfo
+		for _, e := range entries {
+			if !policy.listedEntry(dir, path.Join(r.URL.Path, e.Name()), e) {
+				continue
+			}
+			if fi, err := e.Info(); err == nil {
+				infos = append(infos, fi)
+			}
+		}
+		data := listingData{Path: r.URL.Path, Root: mountPrefix(r), Downloads: true, Thumbnails: thumbs, Search: true}
+		if r.URL.Query().Get("view") == "gallery" {
+			renderGallery(w, data, infos)
+			return
+		}
+		renderListing(w, data, infos)
+	})
+}
+
+// listingEntry describes a directory entry for listing templates.
+type listingEntry struct {
+	Name    string
+	URL     string
+	IsDir   bool
+	Size    int64
+	ModTime time.Time
+	// Thumb is set if a thumbnail is available at URL?thumb.
+	Thumb bool
+	// View names the viewer page available at URL?view=, if any.
+	View string
+}
+
+// listingCrumb is one element of the breadcrumb path to a directory.
+type listingCrumb struct {
+	Name string
+	URL  string
+}
+
+// listingData is passed to listing templates.
+type listingData struct {
+	// Path is the request path of the directory.
+	Path string
+	// Root is the prefix the directory is shared under, if any. Path is
+	// relative to it.
+	Root    string
+	Crumbs  []listingCrumb
+	Entries []listingEntry
+	// Downloads is set if the directory can be fetched as an archive.
+	Downloads bool
+	// Thumbnails is set if image thumbnails can be requested.
+	Thumbnails bool
+	// Gallery is set if the directory contains images to show in a gallery.
+	Gallery bool
+	// Search is set if the tree can be searched at /search.
+	Search bool
+}
+
+var listingFuncs = template.FuncMap{
+	"size": formatSize,
+	"icon": fileIcon,
+}
+
+//go:embed assets/listing.html
+var listingPage string
+
+//go:embed assets/gallery.html
+var galleryPage string
+
+var galleryTemplate = template.Must(template.New("gallery").Parse(galleryPage))
+
+// defaultListingTemplate is the built-in listing page.
+var defaultListingTemplate = template.Must(template.New("listing").Funcs(listingFuncs).Parse(listingPage))
+
+// listingTemplate renders directory listings. If nil, the plain listing in
+// the style of http.FileServer is used.
+var listingTemplate = defaultListingTemplate
+
+// fileIcon returns an emoji hinting at the type of a file.
+func fileIcon(name string, isDir bool) string {
+	if isDir {
+		return "\U0001F4C1"
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".jpg", ".jpeg", ".png", ".gif", ".webp", ".svg", ".bmp", ".heic":
+		return "\U0001F5BC\uFE0F"
+	case ".mp4", ".mkv", ".webm", ".mov", ".avi", ".m4v":
+		return "\U0001F3AC"
+	case ".mp3", ".flac", ".ogg", ".wav", ".m4a", ".opus":
+		return "\U0001F3B5"
+	case ".pdf":
+		return "\U0001F4D5"
+	case ".zip", ".tar", ".gz", ".tgz", ".bz2", ".xz", ".zst", ".7z", ".rar":
+		return "\U0001F4E6"
+	case ".txt", ".md", ".log", ".csv":
+		return "\U0001F4DD"
+	case ".go", ".py", ".js", ".ts", ".c", ".h", ".rs", ".java", ".sh", ".html", ".css", ".json", ".xml", ".yaml", ".yml", ".toml":
+		return "\U0001F4BB"
+	default:
+		return "\U0001F4C4"
+	}
+}
+
+// isImage reports whether browsers can display name as an image.
+func isImage(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".jpg", ".jpeg", ".png", ".gif", ".webp", ".svg", ".bmp", ".avif":
+		return true
+	}
+	return false
+}
+
+// breadcrumbs splits the request path p into links to each ancestor.
+func breadcrumbs(p string) []listingCrumb {
+	token := tokenPrefix.get()
+	prefix := token + "/"
+	crumbs := []listingCrumb{{Name: "home", URL: prefix}}
+	for _, part := range strings.Split(strings.Trim(strings.TrimPrefix(p, token), "/"), "/") {
+		if part == "" {
+			continue
+		}
+		prefix += part + "/"
+		u := url.URL{Path: prefix}
+		crumbs = append(crumbs, listingCrumb{Name: part, URL: u.String()})
+	}
+	return crumbs
+}
+
+// loadListingTemplate parses a user supplied listing template.
+func loadListingTemplate(fn string) (*template.Template, error) {
+	b, err := os.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	return template.New(filepath.Base(fn)).Funcs(listingFuncs).Parse(string(b))
+}
+
+// renderListing writes an html listing of infos, which must be sorted by
+// name. The entries and breadcrumbs of data are filled in from infos and the
+// path.
+func renderListing(w http.ResponseWriter, data listingData, infos []fs.FileInfo) {
+	data = fillListing(data, infos)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if listingTemplate != nil {
+		if err := listingTemplate.Execute(w, data); err != nil {
+			log.Printf("listing template: %v", err)
+		}
+		return
+	}
+	fmt.Fprintf(w, "<!doctype html>\n")
+	fmt.Fprintf(w, "<meta name=\"viewport\" content=\"width=device-width\">\n")
+	if data.Downloads {
+		fmt.Fprintf(w, "<p><a href=\"?format=zip\">Download all (zip)</a> <a href=\"?format=tar.gz\">(tar.gz)</a></p>\n")
+	}
+	fmt.Fprintf(w, "<pre>\n")
+	for _, e := range data.Entries {
+		fmt.Fprintf(w, "<a href=\"%s\">%s</a>\n", e.URL, template.HTMLEscapeString(e.Name))
+	}
+	fmt.Fprintf(w, "</pre>\n")
+}
+
+// fillListing adds breadcrumbs and entries for infos to data.
+func fillListing(data listingData, infos []fs.FileInfo) listingData {
+	data.Crumbs = breadcrumbs(data.Root + data.Path)
+	for _, fi := range infos {
+		name := fi.Name()
+		if fi.IsDir() {
+			name += "/"
+		}
+		u := url.URL{Path: name}
+		if !fi.IsDir() && isImage(name) {
+			data.Gallery = true
+		}
+		data.Entries = append(data.Entries, listingEntry{
+			Name:    name,
+			URL:     u.String(),
+			IsDir:   fi.IsDir(),
+			Size:    fi.Size(),
+			ModTime: fi.ModTime(),
+			Thumb:   data.Thumbnails && !fi.IsDir() && isThumbable(name),
+			View:    fileView(name),
+		})
+	}
+	return data
+}
+
+// renderGallery writes an image grid with a slideshow for the images among
+// infos.
+func renderGallery(w http.ResponseWriter, data listingData, infos []fs.FileInfo) {
+	var images []fs.FileInfo
+	for _, fi := range infos {
+		if !fi.IsDir() && isImage(fi.Name()) {
+			images = append(images, fi)
+		}
+	}
+	data = fillListing(data, images)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := galleryTemplate.Execute(w, data); err != nil {
+		log.Printf("gallery template: %v", err)
+	}
+}
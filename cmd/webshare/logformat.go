@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// jsonLog writes one JSON object per line, for -log-format json.
+type jsonLog struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// requestEntry is the log entry of a request.
+type requestEntry struct {
+	Time      time.Time `json:"time"`
+	Remote    string    `json:"remote"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"`
+	Bytes     int64     `json:"bytes"`
+	Duration  float64   `json:"duration"`
+	Aborted   bool      `json:"aborted,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	Location  string    `json:"location,omitempty"`
+}
+
+// messageEntry is the log entry of anything else logged.
+type messageEntry struct {
+	Time time.Time `json:"time"`
+	Msg  string    `json:"msg"`
+}
+
+// requestLog is set with -log-format json.
+var requestLog *jsonLog
+
+// setLogFormat switches the log to format, text or json.
+func setLogFormat(format string, w io.Writer) error {
+	switch format {
+	case "text":
+	case "json":
+		requestLog = &jsonLog{enc: json.NewEncoder(w)}
+		log.SetFlags(0)
+		log.SetOutput(requestLog)
+	default:
+		return fmt.Errorf("unknown log format %q, want text or json", format)
+	}
+	return nil
+}
+
+func (l *jsonLog) encode(v any) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.enc.Encode(v)
+}
+
+// Write logs a line of the standard logger as a message.
+func (l *jsonLog) Write(p []byte) (int, error) {
+	msg := string(bytes.TrimSpace(p))
+	if err := l.encode(messageEntry{Time: time.Now(), Msg: msg}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Handler wraps h and logs each request once it is done.
+func (l *jsonLog) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &responseRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r)
+		l.encode(requestEntry{
+			Time:      start,
+			Remote:    r.RemoteAddr,
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			Status:    rec.Status(),
+			Bytes:     rec.written,
+			Duration:  time.Since(start).Seconds(),
+			Aborted:   rec.aborted(r),
+			UserAgent: r.UserAgent(),
+			RequestID: requestID(r),
+			Location:  clientLocation(r),
+		})
+	})
+}
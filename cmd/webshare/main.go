@@ -3,31 +3,158 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"mime"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
-	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/mdp/qrterminal"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
 )
 
 var (
-	port      = flag.Int("p", 3000, "port to listen on")
-	directory = flag.String("d", ".", "directory to share")
+	configFn  = flag.String("config", "", "read flags not given on the command line or as WEBSHARE_* environment variables from this toml file, with -hidden, -include and -exclude reloaded on SIGHUP (default config.toml in the user config directory, e.g. ~/.config/webshare)")
+	daemon    = flag.Bool("daemon", false, "keep serving in the background, logging to daemon.log in the user config directory, see webshare stop and status")
+	dryRun    = flag.Bool("dry-run", false, "print the links, qr codes and effective configuration, then exit without listening, to check a setup")
+	pidFn     = flag.String("pid-file", "", "write the pid to this file (default webshare.pid in the user config directory with -daemon)")
+	port      = flag.Int("p", 3000, "port to listen on, 0 to pick a free one")
+	bind      = flag.String("b", "", "address to listen on instead of all interfaces, e.g. 192.168.1.10 or ::1")
+	mounts    mountFlag
+	listenOn  listenFlag
+	vhosts    vhostFlag
+	headers   headerFlag
 	qrPrefix  = flag.String("q", "192", "comma or space separated ip addr prefixes to print qr code for")
+	wifiSSID  = flag.String("wifi", "", "print a qr code to join this wifi network before the one of the link, for guests")
+	wifiPass  = flag.String("wifi-pass", "", "password of the -wifi network, empty for an open one")
+	shortenTo = flag.String("shorten", "", "put short links into the qr codes: words for a memorable alias served by webshare, or the url of a shortener taking the link as the form field url")
+	copyLink  = flag.Bool("copy", false, "put the last printed link with a qr code on the clipboard")
+	useHost   = flag.Bool("use-hostname", false, "print links with the host name, as name.local unless fully qualified, instead of addresses, see -mdns")
+	qrOut     = flag.String("qr-out", "", "also save the qr code of the last printed link as a .png or .svg image, e.g. qr.png")
 	timeout   = flag.Duration("t", 0*time.Second, "temporary share")
+	idleWait  = flag.Duration("idle-timeout", 0, "shut down after this long without requests, e.g. 15m, unlike -t counting from the last one")
+	fromTime  = flag.String("from", "", "only serve from this time of day on, e.g. 09:00, answering 503 outside of -from to -until")
+	untilTime = flag.String("until", "", "only serve until this time of day, e.g. 17:00, the next day if not after -from")
+	openDays  = flag.String("days", "", "only serve on these days with -from and -until, e.g. mon-fri or sat,sun")
+	drainWait = flag.Duration("drain-timeout", 5*time.Minute, "on shutdown, wait this long for transfers in progress to finish")
+	upload    = flag.Bool("upload", false, "allow uploads via POST /upload and PUT")
+	davMode   = flag.Bool("webdav", false, "also serve the directory via webdav, writable with -upload")
+	sftpAddr  = flag.String("sftp", "", "also serve the directory via sftp on this address, e.g. :2022")
+	ftpAddr   = flag.String("ftp", "", "also serve the directory via passive ftp on this address, e.g. :2121")
+	tftpAddr  = flag.String("tftp", "", "also serve the directory via tftp on this address, e.g. :69")
+	stdinMode = flag.Bool("stdin", false, "serve data piped to stdin instead of a directory")
+	stdinName = flag.String("name", "stdin", "file name to serve stdin under")
+	stdinMem  = flag.Bool("stdin-mem", false, "buffer stdin in memory instead of a temporary file")
+	stdinMax  = byteSize(1 << 30)
+	maxBytes  byteSize
+	logSize   byteSize
+	limit     byteRate
+	connLimit byteRate
+	thumbMax  = byteSize(100 << 20)
+	errPages  = errorPageFlag{}
+	archive   = flag.String("archive", "", "serve the contents of this zip, tar or tar.gz file instead of a directory")
+	once      = flag.Bool("once", false, "shut down after the first completed file download, same as -max-downloads 1")
+	maxDL     = flag.Int("max-downloads", 0, "shut down after this many completed file downloads, 0 for no limit")
+	maxFileDL = flag.Int("max-file-downloads", 0, "answer with 410 Gone once a file has been downloaded this many times")
+	gone      = flag.Bool("gone", false, "answer with 410 Gone instead of shutting down when -max-downloads is reached")
+	spa       = flag.Bool("spa", false, "serve index.html for unknown paths, for single-page apps")
+	tmplFile  = flag.String("template", "", "html/template file to render directory listings with")
+	plain     = flag.Bool("plain", false, "use plain directory listings instead of the built-in listing page")
+	attach    = flag.Bool("attach", false, "serve all files as attachments, so browsers download instead of rendering them")
+	mimeTypes = flag.String("mime", "", "content type overrides, e.g. .log=text/plain,.m3u8=application/vnd.apple.mpegurl")
+	mimeFile  = flag.String("mime-file", "", "file with content type overrides in /etc/mime.types format")
+	symlinks  = flag.String("follow-symlinks", "inside", "symlinks to follow: never, inside for those staying within the shared directory, or always")
+	runAs     = flag.String("user", "", "switch to this user after opening the listeners, when started as root, e.g. nobody")
+	sandbox   = flag.Bool("sandbox", false, "restrict file access to the shared directories with landlock and block unneeded syscalls, linux only")
+	jail      = flag.Bool("jail", false, "chroot into the shared directory before serving, linux only, disables thumbnails")
+	proxyTo   = flag.String("proxy", "", "forward all requests to this server instead of sharing a directory, e.g. http://localhost:8080")
+	routes    = flag.String("routes", "", "file with one \"prefix directory-or-url\" route per line")
+	token     = flag.Bool("token", false, "require a random secret path prefix on every request, included in the printed links")
+	signed    = flag.Bool("signed", false, "only serve signed links, see webshare sign")
+	signKey   = flag.String("sign-key", "", "also serve links signed with the key in this file, see webshare sign, which -signed defaults to the one in the user config directory, signed via /api/sign with the -admin token")
+	totp      = flag.Bool("totp", false, "require a code from an authenticator app, set up with the printed qr code")
+	certFile  = flag.String("cert", "", "serve https with this certificate, a PEM file or a .p12 or .pfx bundle, reloaded on SIGHUP")
+	keyFile   = flag.String("key", "", "PEM file with the key for -cert, if not in the same file")
+	certPass  = flag.String("cert-password", "", "password of the -cert bundle")
+	acme      = flag.String("acme", "", "comma or space separated public domain names to get certificates for from Let's Encrypt, serving https on port 443 unless -p is given")
+	acmeEmail = flag.String("acme-email", "", "contact address for the Let's Encrypt account")
+	acmeHTTP  = flag.String("acme-http", ":80", "address to answer HTTP-01 challenges on with -acme, empty to only use TLS-ALPN-01")
+	redirect  = flag.String("http-redirect", "", "also listen for plain http on this address and redirect to https, e.g. :80")
+	useHTTP3  = flag.Bool("http3", false, "also serve https over HTTP/3 on the same udp port")
+	public    = flag.Bool("public", false, "find the address of this host on the internet and print a link for it too")
+	publicVia = flag.String("public-via", "stun.l.google.com:19302", "STUN server for -public, or an https endpoint answering with the address, e.g. https://icanhazip.com")
+	forward   = flag.Bool("expose", false, "ask the router to forward the port from the internet via UPnP or NAT-PMP and print the public link, removed again on exit")
+	tunnelTo  = flag.String("tunnel", "", "make the share reachable from the internet through ssh://user@host, which needs GatewayPorts, or the cloudflared or ngrok client, and print the public link")
+	onion     = flag.Bool("onion", false, "publish the share as a tor onion service via the control port in $TOR_CONTROL, 127.0.0.1:9051 or a tor started for it")
+	tsName    = flag.String("tailscale", "", "serve on the tailnet as a node with this host name instead of on the local network, logging in with $TS_AUTHKEY or the printed link, in builds with -tags tailscale")
+	funnel    = flag.Bool("funnel", false, "with -tailscale, also serve the share to the internet via tailscale funnel on port 443")
+	ssdpMode  = flag.Bool("ssdp", false, "announce the share on the local network via ssdp, so windows network discovery and smart tvs list it")
+	mdnsMode  = flag.Bool("mdns", false, "advertise the share on the local network via mdns, so file managers and browsers can find it")
+	pinCert   = flag.Bool("pin", false, "put the hash of the -tls certificate into the printed links, checked by a page on the server")
+	selfTLS   = flag.Bool("tls", false, "serve https with a self-signed certificate generated on start")
+	mtls      = flag.Bool("mtls", false, "serve https and require a client certificate, from a bundle written to the user config directory or downloadable once")
+	maxConns  = flag.Int("max-conns", 0, "serve at most this many requests at the same time, answering others with 503, 0 for no limit")
+	connQueue = flag.Duration("max-conns-queue", 0, "let requests over -max-conns wait this long for a free slot before answering 503")
+	secure    = flag.Bool("secure-headers", false, "set a restrictive content security policy, nosniff, frame and referrer headers")
+	hosts     = flag.String("allowed-hosts", "", "comma or space separated host names to accept requests for besides addresses, * for any (default localhost and the host name)")
+	cors      = flag.String("cors", "", "comma or space separated origins allowed to fetch from the share, or * for any, e.g. https://app.example.com")
+	private   = flag.Bool("private-only", false, "only serve clients with private, loopback or link-local addresses")
+	accessFn  = flag.String("access-log", "", "append requests to this file in the combined log format, reopened on SIGHUP")
+	summaryFn = flag.String("summary-out", "", "write a summary of the share as JSON to this file on exit")
+	geoipFn   = flag.String("geoip", "", "annotate the log and /_/stats with the country and city of public clients from this MaxMind DB file, e.g. GeoLite2-City.mmdb")
+	logEvery  = flag.Duration("log-rotate", 0, "rotate the -access-log after this long, e.g. 24h, 0 for never")
+	logFiles  = flag.Int("log-max-files", 5, "number of rotated -access-log files to keep as .1, .2 and so on")
+	otelURL   = flag.String("otel-endpoint", "", "export a span per request to this OpenTelemetry collector via OTLP over http, e.g. http://localhost:4318")
+	logFormat = flag.String("log-format", "text", "log format: text, or json for one object per request and message")
+	logTo     = flag.String("log", "stderr", "where to log: stderr, or syslog for the local syslog daemon")
+	logFacil  = flag.String("syslog-facility", "daemon", "syslog facility with -log syslog, e.g. daemon, user or local0")
+	logTag    = flag.String("syslog-tag", "webshare", "syslog tag with -log syslog")
+	webhookTo = flag.String("webhook", "", "post events like downloads, uploads and refused logins as JSON to this url, e.g. a Slack, Matrix or ntfy hook")
+	notify    = flag.Bool("notify", false, "show a desktop notification when a client downloads or uploads a file")
+	adminMode = flag.Bool("admin", false, "serve an api at /_/admin to extend -t, rotate the -token, turn http uploads on and off or shut down with webshare ctl, with a token saved to the user config directory")
+	tuiMode   = flag.Bool("tui", false, "show a live dashboard of transfers, requests and clients with the qr code instead of log lines")
+	hidden    = flag.String("hidden", "hide", "dotfiles: show, hide from listings and deny sensitive ones like .git and .env, or deny")
 )
 
-var privateIPBlocks []*net.IPNet
+var (
+	privateIPBlocks cidrList
+	allowIPs        cidrList
+	denyIPs         cidrList
+)
 
 func init() {
+	flag.Var(routeFlag{}, "route", "serve a directory or proxy to a server below a path, e.g. /api/=http://localhost:8080, repeatable")
+	flag.Var(&vhosts, "vhost", "directory to share for requests to a host name, e.g. docs.local=./docs, repeatable")
+	flag.Var(&listenOn, "listen", "address to listen on instead of -b and -p, host:port or a unix socket like unix:/run/webshare.sock, repeatable")
+	flag.Var(&mounts, "d", "directory to share (default \".\"), repeatable as dir:/prefix to share several under distinct paths")
+	flag.Var(&stdinMax, "stdin-max", "maximum size of data accepted on stdin, 0 for no limit")
+	flag.Var(&logSize, "log-max-size", "rotate the -access-log once it grows beyond this size, e.g. 10MB, 0 for no limit")
+	flag.Var(&maxBytes, "max-bytes", "shut down once this much data has been sent, e.g. 5GB")
+	flag.Var(&limit, "limit", "limit the combined transfer rate of all downloads, e.g. 5MB/s")
+	flag.Var(&connLimit, "limit-per-conn", "limit the transfer rate of each connection, e.g. 1MB/s")
+	flag.Var(&thumbMax, "thumb-cache", "size of the image thumbnail cache, 0 disables thumbnails")
+	flag.Var(&policy.include, "include", "only share files matching this glob, e.g. '*.pdf', repeatable")
+	flag.Var(&policy.exclude, "exclude", "do not share paths matching this glob, e.g. 'node_modules/**', repeatable")
+	flag.Var(&allowIPs, "allow", "only allow clients from this network, e.g. 192.168.1.0/24, repeatable")
+	flag.Var(&denyIPs, "deny", "deny clients from this network unless allowed, e.g. 0.0.0.0/0 for all, ipv6 included, repeatable")
+	flag.Var(&headers, "header", "set a response header, e.g. 'Referrer-Policy: same-origin', an empty value drops one of -secure-headers, repeatable")
+	flag.Var(errPages, "error-page", "custom page for an error status, e.g. 404=notfound.html, repeatable, with {{request_id}} replaced by the id of the request")
 	setupPrivateIPBlocks()
 }
 
@@ -49,7 +176,7 @@ func setupPrivateIPBlocks() {
 		"fe80::/10",      // IPv6 link-local
 		"fc00::/7",       // IPv6 unique local addr
 	} {
-		_, block, err := net.ParseCIDR(cidr)
+		block, err := parseCIDR(cidr)
 		if err != nil {
 			panic(fmt.Errorf("parse error on %q: %v", cidr, err))
 		}
@@ -61,38 +188,549 @@ func isPrivateIP(ip net.IP) bool {
 	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() {
 		return true
 	}
-	for _, block := range privateIPBlocks {
-		if block.Contains(ip) {
-			return true
-		}
-	}
-	return false
+	return privateIPBlocks.contains(ip)
 }
 
 func loggingHandler(h http.Handler) http.Handler {
+	if requestLog != nil {
+		return requestLog.Handler(h)
+	}
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		log.Println(r.RemoteAddr, r.Method, r.URL.Path)
-		fn := path.Join(".", r.URL.Path)
-		file, err := os.Open(fn)
-		if err == nil {
-			defer file.Close()
-			fi, err := file.Stat()
-			if err == nil {
-				log.Printf("%s [%d]", file.Name(), fi.Size())
-			}
+		start := time.Now()
+		rec := &responseRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r)
+		suffix := ""
+		if rec.aborted(r) {
+			suffix = ", aborted by the client"
+		}
+		if loc := clientLocation(r); loc != "" {
+			suffix += " [" + loc + "]"
 		}
-		h.ServeHTTP(w, r)
+		logRequest(r, "%s %s %s %d, %s in %s%s", r.RemoteAddr, r.Method, r.URL.Path, rec.Status(),
+			formatSize(rec.written), time.Since(start).Round(time.Millisecond), suffix)
 	})
 }
 
+// shareDirectory registers the handlers serving the directory of m below its
+// prefix, for requests to host or to any host if empty, with thumbnails
+// kept in thumbCache unless empty.
+func shareDirectory(host string, m mount, thumbCache string) {
+	var fs http.Handler = http.FileServer(http.Dir(m.dir))
+	fs = listingHandler(m.dir, thumbCache != "", fs)
+	if thumbCache != "" {
+		fs = newThumbnailer(m.dir, thumbCache, int64(thumbMax)).Handler(fs)
+	}
+	fs = archiveHandler(m.dir, fs)
+	fs = viewHandler(func(name string) (os.FileInfo, error) { return policy.stat(m.dir, name) }, fs)
+	fs = checksumHandler(m.dir, fs)
+	fs = attachHandler(*attach, fs)
+	if *spa {
+		fs = spaHandler(m.dir, fs)
+	}
+	handle := func(pattern string, h http.Handler) {
+		if host == "" && shadowed(m.prefix, m.prefix+pattern) {
+			return
+		}
+		if m.prefix != "" {
+			h = mountHandler(m.prefix, h)
+		}
+		http.Handle(host+m.prefix+pattern, loggingHandler(h))
+	}
+	// With -admin, uploads can be turned on later.
+	if *upload || *adminMode {
+		fs = putHandler(m.dir, fs)
+		handle("/upload", uploadHandler(m.dir))
+	}
+	if *upload {
+		log.Printf("uploads enabled at %s%s/upload", host, m.prefix)
+	}
+	if *davMode {
+		fs = webdavHandler(m.dir, *upload || *adminMode, fs)
+		log.Printf("webdav enabled at %s%s/", host, m.prefix)
+	}
+	fs = policyHandler(m.dir, fs)
+	handle("/search", searchHandler(m.dir))
+	handle("/api/ls", apiListHandler(m.dir))
+	handle("/api/stat/", apiStatHandler(m.dir))
+	handle("/SHA256SUMS", sumsHandler(m.dir))
+	handle("/", fs)
+}
+
+// httpGates returns the flags given that keep clients out of the http
+// share only, which the other protocols cannot check.
+func httpGates() []string {
+	var gates []string
+	for _, g := range []struct {
+		name string
+		set  bool
+	}{
+		{"-token", *token},
+		{"-totp", *totp},
+		{"-mtls", *mtls},
+		{"-signed", *signed},
+		{"-from", *fromTime != ""},
+		{"-until", *untilTime != ""},
+		{"-days", *openDays != ""},
+	} {
+		if g.set {
+			gates = append(gates, g.name)
+		}
+	}
+	return gates
+}
+
 func main() {
-	flag.Parse()
-	fs := http.FileServer(http.Dir(*directory))
-	http.Handle("/", loggingHandler(fs))
-	addrs, err := net.InterfaceAddrs()
+	flag.Usage = usage
+	command, args := splitCommand(os.Args[1:])
+	switch command {
+	case "serve", "send", "receive":
+		args = parseServeFlags(command, args)
+	case "sign":
+		runSign(args)
+		return
+	case "qr":
+		runQR(args)
+		return
+	case "completion":
+		runCompletion(args)
+		return
+	case "service":
+		runService(args)
+		return
+	case "ctl":
+		runCtl(args)
+		return
+	case "stop", "status":
+		runControl(command, args)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q, see webshare -h\n", command)
+		os.Exit(2)
+	}
+	if err := applyConfig(*configFn); err != nil {
+		log.Fatal(err)
+	}
+	sendFn := setupCommand(command, args)
+	var logOut io.Writer = os.Stderr
+	switch *logTo {
+	case "stderr":
+	case "syslog":
+		w, err := openSyslog(*logFacil, *logTag)
+		if err != nil {
+			log.Fatalf("-log syslog: %v", err)
+		}
+		// Syslog adds its own timestamps.
+		log.SetFlags(0)
+		log.SetOutput(w)
+		logOut = w
+	default:
+		log.Fatalf("-log: want stderr or syslog, got %q", *logTo)
+	}
+	if err := setLogFormat(*logFormat, logOut); err != nil {
+		log.Fatal(err)
+	}
+	if *notify && *sandbox {
+		log.Fatal("-notify does not work with -sandbox, which blocks running the notification command")
+	}
+	if *daemon && !*dryRun {
+		if *tuiMode {
+			log.Fatal("-tui needs a terminal, which -daemon leaves")
+		}
+		if *stdinMode {
+			log.Fatal("-stdin does not work with -daemon, which leaves the input behind")
+		}
+		pidFile := *pidFn
+		if pidFile == "" {
+			pidFile = defaultPidFile()
+		}
+		logFile, err := configFile("daemon.log")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := daemonize(command, pidFile, logFile); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *pidFn != "" && !*dryRun {
+		if err := writePidFile(*pidFn); err != nil {
+			log.Fatal(err)
+		}
+		defer os.Remove(*pidFn)
+	}
+	var hook *webhook
+	if *webhookTo != "" {
+		if u, err := url.Parse(*webhookTo); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			log.Fatalf("-webhook: want an http(s) url, got %q", *webhookTo)
+		}
+		hook = newWebhook(*webhookTo)
+		eventSinks = append(eventSinks, hook.send)
+	}
+	if *notify {
+		sink, err := desktopNotifier()
+		if err != nil {
+			log.Fatalf("-notify: %v", err)
+		}
+		eventSinks = append(eventSinks, sink)
+	}
+	if *tuiMode && !term.IsTerminal(int(os.Stdout.Fd())) {
+		log.Fatal("-tui needs a terminal")
+	}
+	// The log is opened before giving up privileges and sandboxing.
+	var alog *accessLog
+	if *accessFn != "" && !*dryRun {
+		var err error
+		if alog, err = openAccessLog(*accessFn, int64(logSize), *logEvery, *logFiles); err != nil {
+			log.Fatal(err)
+		}
+	}
+	var summaryOut *os.File
+	if *summaryFn != "" && !*dryRun {
+		var err error
+		if summaryOut, err = os.Create(*summaryFn); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *geoipFn != "" {
+		var err error
+		if geo, err = openGeoDB(*geoipFn); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := policy.setHidden(*hidden); err != nil {
+		log.Fatal(err)
+	}
+	if err := policy.setSymlinks(*symlinks); err != nil {
+		log.Fatal(err)
+	}
+	if *mimeFile != "" {
+		if err := addMimeTypesFile(*mimeFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := addMimeTypes(*mimeTypes); err != nil {
+		log.Fatal(err)
+	}
+
+	switch {
+	case *tmplFile != "":
+		t, err := loadListingTemplate(*tmplFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		listingTemplate = t
+	case *plain:
+		listingTemplate = nil
+	}
+	// Everything read from outside the shared directory has to be loaded
+	// before entering the jail.
+	pages, err := loadErrorPages(errPages)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var hostKey ssh.Signer
+	if *sftpAddr != "" {
+		if hostKey, err = loadHostKey(); err != nil {
+			log.Fatalf("sftp host key: %v", err)
+		}
+	}
+	if *routes != "" {
+		if err := loadRoutes(*routes); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if len(mounts) == 0 && !routeTaken("") {
+		mounts = mountFlag{{dir: "."}}
+	}
+	// The other protocols and the jail need a single directory.
+	var directory string
+	if len(mounts) == 1 && len(vhosts) == 0 && len(proxies) == 0 {
+		directory = mounts[0].dir
+	} else if *sftpAddr != "" || *ftpAddr != "" || *tftpAddr != "" || *jail {
+		log.Fatal("-sftp, -ftp, -tftp and -jail only work with a single directory and no proxy routes")
+	}
+	if gates := httpGates(); len(gates) > 0 {
+		if *sftpAddr != "" {
+			log.Fatalf("-sftp lets everyone in, which would get around %s", strings.Join(gates, ", "))
+		}
+		if *ftpAddr != "" {
+			log.Fatalf("-ftp takes any password, which would get around %s", strings.Join(gates, ", "))
+		}
+		if *tftpAddr != "" {
+			log.Fatalf("-tftp has no authentication, which would get around %s", strings.Join(gates, ", "))
+		}
+	}
+	var gate *totpGate
+	if *totp {
+		secret, err := loadTOTPSecret()
+		if err != nil {
+			log.Fatal(err)
+		}
+		gate = newTOTPGate(secret)
+	}
+	// The admin token also protects /api/sign.
+	var adminToken string
+	if *adminMode {
+		adminToken = randomToken(20)
+	}
+	var signer *linkSigner
+	if *signed || *signKey != "" {
+		key, err := loadSignKey(*signKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+		signer = &linkSigner{key: key, apiToken: adminToken}
+	}
+	var tlsConfig *tls.Config
+	var bundle *bundleDownload
+	var bundleFile, bundlePassword, caFingerprint string
+	if *mtls {
+		ca, err := loadCA()
+		if err != nil {
+			log.Fatalf("mtls: %v", err)
+		}
+		if tlsConfig, err = ca.tlsConfig(); err != nil {
+			log.Fatalf("mtls: %v", err)
+		}
+		bundlePassword = randomToken(5)
+		b, err := ca.clientBundle(bundlePassword)
+		if err != nil {
+			log.Fatalf("mtls: %v", err)
+		}
+		if bundleFile, err = configFile("webshare.p12"); err != nil {
+			log.Fatalf("mtls: %v", err)
+		}
+		if err := os.WriteFile(bundleFile, b, 0600); err != nil {
+			log.Fatalf("mtls: %v", err)
+		}
+		bundle = &bundleDownload{path: "/_/mtls/" + randomToken(10), bundle: b}
+		caFingerprint = fingerprint(ca.cert)
+	}
+	var certs *certLoader
+	if *certFile != "" {
+		certs = &certLoader{certFile: *certFile, keyFile: *keyFile, password: *certPass}
+		if err := certs.load(); err != nil {
+			log.Fatalf("tls: %v", err)
+		}
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		}
+		tlsConfig.Certificates = nil
+		tlsConfig.GetCertificate = certs.GetCertificate
+	}
+	var acmeManager *autocert.Manager
+	var acmeDomains []string
+	var acmeCache string
+	if *acme != "" {
+		if certs != nil || *jail {
+			log.Fatal("-acme does not work with -cert or -jail")
+		}
+		if acmeCache, err = configFile("acme"); err != nil {
+			log.Fatalf("acme: %v", err)
+		}
+		acmeDomains = parsePrefixes(*acme)
+		acmeManager = newACMEManager(acmeDomains, *acmeEmail, acmeCache)
+		tlsConfig = acmeTLSConfig(acmeManager, tlsConfig)
+		if !flagSet("p") {
+			*port = 443
+		}
+	}
+	var certFingerprint string
+	var pin *certPin
+	if *selfTLS && tlsConfig == nil {
+		cert, err := selfSignedCert()
+		if err != nil {
+			log.Fatalf("tls: %v", err)
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		certFingerprint = fingerprint(cert.Leaf)
+		if *pinCert {
+			pin = &certPin{cert: cert.Leaf}
+		}
+	}
+	if *pinCert && pin == nil {
+		log.Fatal("-pin only works with the self-signed certificate of -tls")
+	}
+	var creds *credentials
+	if *runAs != "" {
+		if creds, err = lookupUser(*runAs); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *jail {
+		if *stdinMode || sendFn != "" || *archive != "" || *proxyTo != "" {
+			log.Fatal("-jail only works when sharing a directory")
+		}
+		if !*dryRun {
+			if err := enterJail(directory); err != nil {
+				log.Fatal(err)
+			}
+			log.Printf("jailed to %s", directory)
+			directory, mounts[0].dir = "/", "/"
+		}
+	}
+
+	// Path appended to the printed links, if not the root.
+	var urlPath string
+	// Thumbnails are only shown by the listing page.
+	var thumbCache string
+	if *stdinMode {
+		sf := readStdin(os.Stdin, *stdinName, int64(stdinMax), *stdinMem)
+		defer sf.Close()
+		http.Handle("/", loggingHandler(sf))
+		u := url.URL{Path: "/" + *stdinName}
+		urlPath = u.String()
+	} else if sendFn != "" {
+		sf, err := openFile(sendFn)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if flagSet("name") {
+			sf.name = *stdinName
+		}
+		defer sf.Close()
+		http.Handle("/", loggingHandler(sf))
+		u := url.URL{Path: "/" + sf.name}
+		urlPath = u.String()
+		log.Printf("sending %s [%d]", sendFn, sf.size)
+	} else if command == "receive" {
+		http.Handle("/", loggingHandler(receiveHandler(directory)))
+		log.Printf("receiving uploads into %s", directory)
+	} else if *archive != "" {
+		as, err := openArchive(*archive)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer as.Close()
+		http.Handle("/", loggingHandler(attachHandler(*attach, viewHandler(as.stat, as))))
+	} else if *proxyTo != "" {
+		u, err := parseUpstream(*proxyTo)
+		if err != nil {
+			log.Fatal(err)
+		}
+		http.Handle("/", loggingHandler(proxyHandler(u)))
+		log.Printf("proxying to %s", u)
+	} else {
+		if thumbMax > 0 && *tmplFile == "" && !*plain && !*jail {
+			if thumbCache, err = thumbCacheDir(); err != nil {
+				log.Printf("thumbnails disabled: %v", err)
+			}
+		}
+		root := false
+		for _, m := range mounts {
+			shareDirectory("", m, thumbCache)
+			root = root || m.prefix == ""
+		}
+		for _, p := range proxies {
+			http.Handle(p.prefix+"/", loggingHandler(proxyHandler(p.target)))
+			log.Printf("proxying %s/ to %s", p.prefix, p.target)
+			root = root || p.prefix == ""
+		}
+		for _, v := range vhosts {
+			shareDirectory(v.host, mount{dir: v.dir}, thumbCache)
+			log.Printf("serving %s for host %s", v.dir, v.host)
+		}
+		if !root {
+			http.Handle("/", loggingHandler(mountRootHandler(mounts)))
+		}
+	}
+	if *token {
+		tokenPrefix.set("/s/" + randomToken(10))
+		if urlPath == "" {
+			urlPath = "/"
+		}
+		urlPath = tokenPrefix.get() + urlPath
+	}
+	scheme := "http"
+	if tlsConfig != nil {
+		scheme = "https"
+	}
+	if len(listenOn) > 0 && (*useHTTP3 || *redirect != "") {
+		log.Fatal("-http3 and -http-redirect do not work with -listen")
+	}
+	if *bind != "" && net.ParseIP(*bind) == nil {
+		log.Fatalf("invalid -b address %q", *bind)
+	}
+	activated, err := systemdListeners()
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, ln := range activated {
+		log.Printf("listening on %s from systemd", ln.Addr())
+	}
+	ifaces, err := net.Interfaces()
 	if err != nil {
 		log.Fatal(err)
 	}
+	// The listeners are opened before printing the links, so they show the
+	// port picked by the kernel with -p 0. They are served further below,
+	// after giving up privileges.
+	// On the tailnet, local listeners are only opened if asked for.
+	// With -dry-run, the addresses are only resolved.
+	lns := activated
+	var addrs []net.Addr
+	for _, ln := range lns {
+		addrs = append(addrs, ln.Addr())
+	}
+	if len(lns) == 0 && (*tsName == "" || len(listenOn) > 0) {
+		listenAddrs := listenOn
+		if len(listenAddrs) == 0 {
+			listenAddrs = listenFlag{net.JoinHostPort(*bind, strconv.Itoa(*port))}
+		}
+		for _, addr := range listenAddrs {
+			if *dryRun {
+				a, err := plannedAddr(addr)
+				if err != nil {
+					log.Fatal(err)
+				}
+				addrs = append(addrs, a)
+				continue
+			}
+			ln, err := listen(addr)
+			if err != nil {
+				log.Fatal(err)
+			}
+			lns = append(lns, ln)
+			addrs = append(addrs, ln.Addr())
+		}
+	}
+	var eps []endpoint
+	for _, a := range addrs {
+		addr, ok := a.(*net.TCPAddr)
+		if !ok {
+			// There are no links to print for sockets.
+			if len(activated) == 0 {
+				log.Printf("listening on unix:%s", a)
+			}
+			continue
+		}
+		if len(eps) == 0 {
+			*port = addr.Port
+		}
+		eps = append(eps, endpoints(addr.IP.String(), addr.Port, ifaces)...)
+	}
+	var short shortener
+	var alias *aliasShortener
+	if *shortenTo == "words" && (*token || *totp || *signed) {
+		// The alias is easy to guess and would skip the secret.
+		log.Fatal("-shorten words does not work with -token, -totp or -signed")
+	}
+	if *shortenTo != "" {
+		target := urlPath
+		if pin != nil {
+			target = pin.link("", urlPath)
+		} else if target == "" {
+			target = "/"
+		}
+		if short, err = newShortener(*shortenTo, target); err != nil {
+			log.Fatalf("-shorten: %v", err)
+		}
+		alias, _ = short.(*aliasShortener)
+		if _, ok := short.(*remoteShortener); ok && *dryRun {
+			log.Printf("-shorten: not asking %s in a dry run", *shortenTo)
+			short = nil
+		}
+	}
 	config := qrterminal.Config{
 		Level:     qrterminal.M,
 		Writer:    os.Stdout,
@@ -100,6 +738,31 @@ func main() {
 		WhiteChar: qrterminal.BLACK,
 		QuietZone: 1,
 	}
+	// With -dry-run, stdout only gets the links.
+	if *dryRun {
+		config.Writer = os.Stderr
+	}
+	// The last link with a qr code is the most widely reachable one, which
+	// goes into the -qr-out image and on the clipboard with -copy.
+	var qrLink string
+	showQR := func(link string) {
+		if short != nil {
+			if s, err := short.shorten(link); err != nil {
+				log.Printf("shortening %s: %v", link, err)
+			} else {
+				log.Printf("%s [short]", termLink(s))
+				link = s
+			}
+		}
+		qrterminal.GenerateWithConfig(link, config)
+		qrLink = link
+	}
+
+	// Guests join the network first, then scan the link.
+	if *wifiSSID != "" {
+		log.Printf("scan to join the wifi network %q", *wifiSSID)
+		qrterminal.GenerateWithConfig(wifiURI(*wifiSSID, *wifiPass), config)
+	}
 
 	// Parse the prefixes from the flag
 	prefixes := parsePrefixes(*qrPrefix)
@@ -108,84 +771,804 @@ func main() {
 	var qrGenerated bool
 	var fallbackIP net.IP
 	var fallbackLink string
+	// On a terminal, the link for the qr code can be picked unless -q is
+	// given.
+	var links []string
+	var matched []int
 
-	for _, addr := range addrs {
-		if ipnet, ok := addr.(*net.IPNet); ok {
-			if ipnet.IP.To4() != nil {
-				mark := "public"
-				if isPrivateIP(ipnet.IP) {
-					mark = "private"
-				}
-				link := fmt.Sprintf("http://%s:%d", ipnet.IP.String(), *port)
-				log.Printf("%s [%s]", link, mark)
-
-				// Check if IP matches any of the prefixes
-				for _, prefix := range prefixes {
-					if strings.HasPrefix(ipnet.IP.String(), prefix) {
-						qrterminal.GenerateWithConfig(link, config)
-						qrGenerated = true
-						break // Only generate QR code once per matching IP
-					}
-				}
+	// The address on the internet gets a link too, unless an interface
+	// has it.
+	linkEps := eps
+	if *useHost {
+		name, err := advertisedHostname()
+		if err != nil {
+			log.Fatalf("-use-hostname: %v", err)
+		}
+		linkEps = hostnameEndpoints(eps, name)
+	}
+	if *public {
+		ip, err := publicIP(context.Background(), *publicVia)
+		if err != nil {
+			log.Fatalf("-public: %v", err)
+		}
+		if !slices.ContainsFunc(eps, func(e endpoint) bool { return e.ip.Equal(ip) }) {
+			log.Printf("public address %s is not on an interface, the link only works with a port forwarding, see -expose", ip)
+			linkEps = append(slices.Clip(linkEps), endpoint{host: ip.String(), ip: ip, port: *port, explicit: true})
+		}
+	}
 
-				// Store first public IP as potential fallback
-				if !isPrivateIP(ipnet.IP) && fallbackIP == nil {
-					fallbackIP = ipnet.IP
-					fallbackLink = link
-				}
+	for _, e := range linkEps {
+		mark := "public"
+		if e.ip == nil {
+			mark = "name"
+		} else if e.linkLocal() {
+			mark = "link-local"
+		} else if isPrivateIP(e.ip) {
+			mark = "private"
+		}
+		// The zone of link-local addresses is escaped in urls.
+		base := (&url.URL{Scheme: scheme, Host: net.JoinHostPort(e.host, strconv.Itoa(e.port))}).String()
+		link := base + urlPath
+		if pin != nil {
+			link = pin.link(base, urlPath)
+		}
+		log.Printf("%s [%s]", termLink(link), mark)
+		if e.linkLocal() {
+			continue
+		}
+		links = append(links, link)
+
+		// Check if IP matches any of the prefixes
+		if *useHost && e.ip == nil {
+			matched = append(matched, len(links)-1)
+			continue
+		}
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(e.host, prefix) {
+				matched = append(matched, len(links)-1)
+				break // Only generate QR code once per matching IP
 			}
 		}
+
+		// Store first public IP as potential fallback
+		if mark == "public" && fallbackIP == nil {
+			fallbackIP = e.ip
+			fallbackLink = link
+		}
+	}
+
+	if len(links) > 1 && !flagSet("q") && !*stdinMode && sendFn == "" && !*dryRun && canPick() {
+		def := 0
+		if len(matched) > 0 {
+			def = matched[0]
+		} else if i := slices.Index(links, fallbackLink); i >= 0 {
+			def = i
+		}
+		showQR(links[pickLink(links, def)])
+		qrGenerated = true
+	} else {
+		for _, i := range matched {
+			showQR(links[i])
+			qrGenerated = true
+		}
+	}
+
+	var acmeLinks []string
+	for i, domain := range acmeDomains {
+		link := fmt.Sprintf("https://%s%s", domain, urlPath)
+		if *port != 443 {
+			link = fmt.Sprintf("https://%s:%d%s", domain, *port, urlPath)
+		}
+		acmeLinks = append(acmeLinks, link)
+		log.Printf("%s [acme]", termLink(link))
+		if i == 0 {
+			showQR(link)
+			qrGenerated = true
+		}
 	}
 
 	// If no QR code was generated and we have a public IP fallback, use it
 	if !qrGenerated && fallbackIP != nil {
-		qrterminal.GenerateWithConfig(fallbackLink, config)
+		showQR(fallbackLink)
 	}
 
-	// Create server instance
-	srv := &http.Server{
-		Addr: fmt.Sprintf(":%d", *port),
+	if gate != nil {
+		log.Printf("scan to set up your authenticator app: %s", gate.provisioningURI())
+		qrterminal.GenerateWithConfig(gate.provisioningURI(), config)
+	}
+	if certFingerprint != "" {
+		log.Printf("self-signed certificate fingerprint %s", certFingerprint)
+	}
+	if bundle != nil {
+		log.Printf("client certificate bundle at %s, password %s, or download it once from %s on any address above", bundleFile, bundlePassword, bundle.path)
+		log.Printf("certificate authority fingerprint %s", caFingerprint)
+	}
+	if *dryRun {
+		reportDryRun(command, sendFn, append(links, acmeLinks...))
+		return
 	}
 
 	// Create context for shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	var mapping *portMapping
+	if *forward {
+		if len(lanIPs(eps)) == 0 {
+			log.Fatal("-expose needs a listener on a local network address")
+		}
+		if mapping, err = expose(ctx, *port); err != nil {
+			log.Fatalf("-expose: %v", err)
+		}
+		log.Printf("forwarding port %d via %s", mapping.external, mapping.mapper)
+		base := fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(mapping.ip.String(), strconv.Itoa(mapping.external)))
+		link := base + urlPath
+		if pin != nil {
+			link = pin.link(base, urlPath)
+		}
+		log.Printf("%s [internet]", termLink(link))
+		// Behind carrier-grade or another NAT, the router has no public
+		// address itself.
+		_, cgnat, _ := net.ParseCIDR("100.64.0.0/10")
+		if isPrivateIP(mapping.ip) || cgnat.Contains(mapping.ip) {
+			log.Printf("the router's external address %s is not public, the link only works from within that network", mapping.ip)
+		}
+		showQR(link)
+	}
+
+	var tunnelURL *url.URL
+	var tunnelLn net.Listener
+	if *tunnelTo != "" {
+		t, err := newTunnel(*tunnelTo)
+		if err != nil {
+			log.Fatal(err)
+		}
+		local := tunnelTarget(scheme, lns)
+		if local == nil {
+			log.Fatal("-tunnel needs a tcp listener")
+		}
+		if _, ok := t.(*commandTunnel); ok && *private {
+			// The tunnel client connects from this host.
+			log.Fatalf("-private-only would let everyone in through %s", t)
+		}
+		if tunnelURL, tunnelLn, err = t.open(ctx, local); err != nil {
+			log.Fatalf("-tunnel: %v", err)
+		}
+		log.Printf("tunneling via %s", t)
+		base := strings.TrimSuffix(tunnelURL.String(), "/")
+		link := base + urlPath
+		// Only the ssh server passes on the connections with our certificate.
+		if pin != nil && tunnelLn != nil {
+			link = pin.link(base, urlPath)
+		}
+		log.Printf("%s [tunnel]", termLink(link))
+		showQR(link)
+	}
+
+	var onionSvc *onionService
+	if *onion {
+		local := tunnelTarget(scheme, lns)
+		if local == nil {
+			log.Fatal("-onion needs a tcp listener")
+		}
+		if *private {
+			log.Fatal("-private-only would let everyone in through tor")
+		}
+		virtual := 80
+		if scheme == "https" {
+			virtual = 443
+		}
+		if onionSvc, err = publishOnion(ctx, virtual, local); err != nil {
+			log.Fatalf("-onion: %v", err)
+		}
+		link := fmt.Sprintf("%s://%s%s", scheme, onionSvc.host, urlPath)
+		if pin != nil {
+			link = pin.link(scheme+"://"+onionSvc.host, urlPath)
+		}
+		log.Printf("%s [onion]", termLink(link))
+		log.Printf("the onion service may take a minute to become reachable")
+		showQR(link)
+	}
+
+	var node *tailnetNode
+	var funnelLn net.Listener
+	if *funnel && *tsName == "" {
+		log.Fatal("-funnel needs -tailscale")
+	}
+	if *tsName != "" {
+		if node, err = joinTailnet(ctx, *tsName); err != nil {
+			log.Fatalf("-tailscale: %v", err)
+		}
+		if *port == 0 {
+			*port = 80
+			if scheme == "https" {
+				*port = 443
+			}
+		}
+		ln, err := node.listen(*port)
+		if err != nil {
+			log.Fatalf("-tailscale: %v", err)
+		}
+		lns = append(lns, ln)
+		base := fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(node.dnsName, strconv.Itoa(*port)))
+		link := base + urlPath
+		if pin != nil {
+			link = pin.link(base, urlPath)
+		}
+		log.Printf("%s [tailnet]", termLink(link))
+		showQR(link)
+		if *funnel {
+			if funnelLn, err = node.funnel(); err != nil {
+				log.Fatalf("-funnel: %v", err)
+			}
+			// Funnel serves the certificate of the tailnet.
+			link := "https://" + node.dnsName + urlPath
+			log.Printf("%s [funnel]", termLink(link))
+			showQR(link)
+		}
+	}
+
+	if *qrOut != "" {
+		if qrLink == "" {
+			log.Fatal("-qr-out: no link with a qr code, see -q")
+		}
+		if err := writeQR(*qrOut, qrLink); err != nil {
+			log.Fatalf("-qr-out: %v", err)
+		}
+		log.Printf("saved the qr code of %s to %s", qrLink, *qrOut)
+	}
+	if *copyLink && qrLink != "" {
+		if err := copyToClipboard(qrLink); err != nil {
+			log.Printf("-copy: %v", err)
+		} else {
+			log.Printf("copied %s to the clipboard", qrLink)
+		}
+	}
+
+	var handler http.Handler = http.DefaultServeMux
+	if len(vhosts) > 0 {
+		handler = vhostHandler(handler)
+	}
+	stats := newDownloadStats()
+	handler = stats.Handler(handler)
+	direct := handler
+	handler = (&shareQR{path: strings.TrimPrefix(urlPath, tokenPrefix.get()), pin: pin}).Handler(handler)
+	if gate != nil {
+		handler = gate.Handler(handler)
+	}
+	if tokenPrefix.get() != "" {
+		handler = tokenPrefix.Handler(handler)
+	}
+	// Signed links work without the token.
+	if signer != nil {
+		handler = signer.Handler(*signed, direct, handler)
+	}
+	if len(pages) > 0 {
+		handler = errorPageHandler(pages, handler)
+	}
+	if *cors != "" {
+		handler = corsHandler(parsePrefixes(*cors), *upload || *adminMode, handler)
+	}
+	if hdr := responseHeaders(*secure, headers); len(hdr) > 0 {
+		handler = headerHandler(hdr, handler)
+	}
+	if *once {
+		*maxDL = 1
+	}
+	if *maxDL > 0 || *maxFileDL > 0 {
+		limiter := &downloadLimiter{
+			maxTotal:   *maxDL,
+			maxPerFile: *maxFileDL,
+			gone:       *gone,
+			exhausted:  cancel,
+		}
+		handler = limiter.Handler(handler)
+	}
+	if maxBytes > 0 {
+		budget := &byteBudget{max: int64(maxBytes), exhausted: cancel}
+		handler = budget.Handler(handler)
+	}
+	if *maxConns > 0 {
+		handler = newRequestLimiter(*maxConns, *connQueue).Handler(handler)
+	}
+	var throttled *throttle
+	if limit > 0 || connLimit > 0 {
+		throttled = &throttle{global: newLimiter(limit), perConn: connLimit}
+		handler = throttled.Handler(handler)
+	}
+	if pin != nil {
+		handler = pin.Handler(handler)
+	}
+	if alias != nil {
+		handler = alias.Handler(handler)
+	}
+	var ssdp *ssdpDevice
+	if *ssdpMode {
+		// Like with mdns, the token is left out.
+		ssdp = newSSDP(scheme, *port, strings.TrimPrefix(urlPath, tokenPrefix.get()))
+		if ssdp.presentation == "" {
+			ssdp.presentation = "/"
+		}
+		handler = ssdp.Handler(handler)
+	}
+	if bundle != nil {
+		handler = bundle.Handler(handler)
+	}
+	var open *window
+	if *fromTime != "" || *untilTime != "" || *openDays != "" {
+		if open, err = parseWindow(*fromTime, *untilTime, *openDays); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("serving from %s", open)
+		handler = open.Handler(handler)
+	}
+	// The probes work without the token and outside of the window.
+	var shared []string
+	for _, m := range mounts {
+		shared = append(shared, m.dir)
+	}
+	for _, v := range vhosts {
+		shared = append(shared, v.dir)
+	}
+	if *stdinMode || sendFn != "" || *archive != "" || *proxyTo != "" {
+		shared = nil
+	}
+	handler = newHealthCheck(ctx, shared, open, adminToken).Handler(handler)
+	// The admin api works without the token and the totp code.
+	var admin *shareAdmin
+	if *adminMode {
+		uploadsOff.Store(!*upload)
+		admin = &shareAdmin{
+			token:      adminToken,
+			path:       strings.TrimPrefix(urlPath, tokenPrefix.get()),
+			link:       qrLink,
+			uploadable: !*stdinMode && sendFn == "" && *archive == "" && *proxyTo == "" && (*upload || !*sandbox),
+			shutdown:   cancel,
+		}
+		handler = admin.Handler(handler)
+		if fn, err := writeAdminAccess(adminAccess{URL: adminURL(scheme, eps), Token: admin.token}); err != nil {
+			log.Printf("-admin: %v, use webshare ctl -token %s", err, admin.token)
+		} else {
+			defer os.Remove(fn)
+			log.Printf("admin api at %s, with the token in %s for webshare ctl", adminPath, fn)
+		}
+	}
+	if len(allowIPs) > 0 || len(denyIPs) > 0 {
+		handler = accessHandler(allowIPs, denyIPs, handler)
+	}
+	if *private {
+		handler = privateHandler(handler)
+	}
+	// Behind a socket, the proxy in front decides which hosts reach the
+	// share.
+	if *hosts != "*" && (*hosts != "" || !listenOn.sockets()) {
+		allowed := parsePrefixes(*hosts)
+		if len(allowed) == 0 {
+			allowed = defaultHosts()
+		}
+		for _, v := range vhosts {
+			allowed = append(allowed, v.host)
+		}
+		allowed = append(allowed, acmeDomains...)
+		if tunnelURL != nil {
+			allowed = append(allowed, tunnelURL.Hostname())
+		}
+		if node != nil {
+			allowed = append(allowed, node.dnsName, *tsName)
+		}
+		if onionSvc != nil {
+			allowed = append(allowed, onionSvc.host)
+		}
+		handler = hostHandler(allowed, handler)
+	}
+	// The access log sees every request, also those denied above.
+	if alog != nil {
+		handler = alog.Handler(handler)
+	}
+	var spans *tracer
+	if *otelURL != "" {
+		spans = newTracer(ctx, *otelURL, &tokenPrefix)
+		handler = spans.Handler(handler)
+	}
+	var dash *dashboard
+	if *tuiMode {
+		dash = newDashboard(qrLink)
+		handler = dash.Handler(handler)
+	}
+	counter := newRequestCounter()
+	handler = counter.Handler(handler)
+	if *idleWait > 0 {
+		log.Printf("Server will shut down after %v without requests", *idleWait)
+		handler = newIdleWatch(*idleWait, cancel).Handler(handler)
+	}
+	handler = requestIDHandler(handler)
+
+	// Create server instance
+	srv := &http.Server{
+		Addr:      net.JoinHostPort(*bind, strconv.Itoa(*port)),
+		Handler:   handler,
+		TLSConfig: tlsConfig,
+	}
+	var conns connTracker
+	srv.ConnState = conns.ConnState
+	if throttled != nil {
+		srv.ConnContext = throttled.ConnContext
+	}
+	var h3 *http3.Server
+	if *useHTTP3 {
+		if tlsConfig == nil {
+			log.Fatal("-http3 needs https, see -tls, -cert or -acme")
+		}
+		h3 = newHTTP3Server(*port, tlsConfig, handler)
+		if throttled != nil {
+			h3.ConnContext = func(ctx context.Context, _ *quic.Conn) context.Context {
+				return throttled.ConnContext(ctx, nil)
+			}
+		}
+		srv.Handler = altSvcHandler(h3, handler)
+	}
+
 	// Handle timeout
 	if *timeout > 0 {
 		log.Printf("Server will shut down after %v", *timeout)
-		time.AfterFunc(*timeout, func() {
-			cancel()
-		})
+		shutdownAt := newDeadline(*timeout, cancel)
+		if admin != nil {
+			admin.deadline = shutdownAt
+		}
 	}
 
-	// Handle interrupt signals
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	// Open the remaining listeners before giving up privileges, which may be
+	// needed for low ports.
+	var acmeLn net.Listener
+	if acmeManager != nil && *acmeHTTP != "" {
+		if acmeLn, err = net.Listen("tcp", *acmeHTTP); err != nil {
+			log.Printf("acme: no HTTP-01 challenges: %v", err)
+		}
+	}
+	// The redirect shares the listener for HTTP-01 challenges if both use
+	// the same address.
+	var redirectLn net.Listener
+	var acmeFallback http.Handler
+	if *redirect != "" {
+		if tlsConfig == nil {
+			log.Fatal("-http-redirect needs https, see -tls, -cert or -acme")
+		}
+		if acmeLn != nil && *redirect == *acmeHTTP {
+			acmeFallback = redirectHandler(*port)
+		} else if redirectLn, err = net.Listen("tcp", *redirect); err != nil {
+			log.Fatal(err)
+		}
+	}
+	var h3Conn net.PacketConn
+	if h3 != nil {
+		if h3Conn, err = net.ListenPacket("udp", srv.Addr); err != nil {
+			log.Fatal(err)
+		}
+	}
+	var sftpLn, ftpLn net.Listener
+	var tftpConn net.PacketConn
+	if *sftpAddr != "" {
+		if sftpLn, err = net.Listen("tcp", *sftpAddr); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *ftpAddr != "" {
+		if ftpLn, err = net.Listen("tcp", *ftpAddr); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *tftpAddr != "" {
+		if tftpConn, err = net.ListenPacket("udp", *tftpAddr); err != nil {
+			log.Fatal(err)
+		}
+	}
+	var mdns *mdnsResponder
+	if *mdnsMode {
+		ips := lanIPs(eps)
+		if len(ips) == 0 {
+			log.Fatal("-mdns needs a listener on a local network address")
+		}
+		services := []string{"_" + scheme + "._tcp"}
+		if *davMode {
+			services = append(services, "_webdav._tcp")
+			if scheme == "https" {
+				services[1] = "_webdavs._tcp"
+			}
+		}
+		// The token stays out of the announcements, which anyone can see.
+		var txt []string
+		if p := strings.TrimPrefix(urlPath, tokenPrefix.get()); p != "" {
+			txt = append(txt, "path="+p)
+		}
+		if mdns, err = newMDNS(services, *port, ips, txt); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("advertising %s via mdns as %q", strings.Join(services, ", "), mdns.instance)
+	}
+	if ssdp != nil {
+		if err := ssdp.listen(lanIPs(eps)); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("announcing %q via ssdp", ssdp.name)
+	}
+	if creds != nil {
+		if err := creds.drop(); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("running as user %s", creds.name)
+	}
+	if *sandbox {
+		// The system mime types are only read on first use.
+		mime.TypeByExtension(".html")
+		var dirs, rw []string
+		for _, m := range mounts {
+			dirs = append(dirs, m.dir)
+		}
+		for _, v := range vhosts {
+			dirs = append(dirs, v.dir)
+		}
+		if *upload {
+			dirs, rw = nil, dirs
+		}
+		if acmeCache != "" {
+			rw = append(rw, acmeCache)
+		}
+		if *stdinMode {
+			rw = append(rw, os.TempDir())
+		} else if thumbCache != "" {
+			rw = append(rw, thumbCache)
+		}
+		if alog != nil {
+			// Rotating and reopening on SIGHUP create files next to it.
+			rw = append(rw, filepath.Dir(*accessFn))
+		}
+		if err := enterSandbox(dirs, rw); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("sandboxed to %s", strings.Join(append(dirs, rw...), ", "))
+	}
+
+	if acmeLn != nil {
+		go func() {
+			if err := http.Serve(acmeLn, acmeManager.HTTPHandler(acmeFallback)); err != nil {
+				log.Printf("acme: %v", err)
+			}
+		}()
+	}
+
+	if h3Conn != nil {
+		go func() {
+			if err := h3.Serve(h3Conn); err != nil && err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	if redirectLn != nil {
+		go func() {
+			if err := http.Serve(redirectLn, redirectHandler(*port)); err != nil {
+				log.Printf("http redirect: %v", err)
+			}
+		}()
+	}
+
+	if sftpLn != nil {
+		go func() {
+			if err := serveSFTP(ctx, sftpLn, directory, hostKey, *upload); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	if ftpLn != nil {
+		go func() {
+			if err := serveFTP(ctx, ftpLn, directory, *upload); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	if tftpConn != nil {
+		go func() {
+			if err := serveTFTP(ctx, tftpConn, directory, *upload); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	ssdpDone := make(chan struct{})
+	if ssdp != nil {
+		go func() {
+			ssdp.serve(ctx)
+			close(ssdpDone)
+		}()
+	} else {
+		close(ssdpDone)
+	}
+
+	mdnsDone := make(chan struct{})
+	if mdns != nil {
+		go func() {
+			mdns.serve(ctx)
+			close(mdnsDone)
+		}()
+	} else {
+		close(mdnsDone)
+	}
+
+	// SIGHUP reloads the config file, the credentials and certificate files
+	// and reopens the access log, leaving the connections alone.
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
 	go func() {
-		<-sigChan
-		log.Println("\nReceived interrupt signal, shutting down...")
-		cancel()
+		for range hupChan {
+			log.Println("Received hangup signal, reloading...")
+			if err := reloadPolicy(*configFn); err != nil {
+				log.Printf("reloading config: %v", err)
+			}
+			if gate != nil {
+				if secret, err := loadTOTPSecret(); err != nil {
+					log.Printf("reloading totp secret: %v", err)
+				} else if gate.setSecret(secret) {
+					log.Printf("totp secret changed, scan to set up your authenticator app again: %s", gate.provisioningURI())
+				}
+			}
+			if signer != nil {
+				if key, err := loadSignKey(*signKey); err != nil {
+					log.Printf("reloading sign key: %v", err)
+				} else {
+					signer.setKey(key)
+				}
+			}
+			if certs != nil {
+				if err := certs.load(); err != nil {
+					log.Printf("reloading certificate: %v", err)
+				}
+			}
+			if alog != nil {
+				if err := alog.reopen(); err != nil {
+					log.Printf("reopening access log: %v", err)
+				}
+			}
+		}
 	}()
 
-	// Start server in a goroutine
+	// Handle interrupt signals
+	// Another one while shutting down cuts off the transfers.
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	notifyService(sigChan)
+	forceStop := make(chan struct{})
 	go func() {
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatal(err)
+		for range sigChan {
+			if ctx.Err() != nil {
+				close(forceStop)
+				return
+			}
+			log.Println("\nReceived interrupt signal, shutting down...")
+			cancel()
 		}
 	}()
 
+	// Start server in a goroutine per listener. Serve fills in TLSConfig,
+	// so whether to use TLS is decided before.
+	useTLS := srv.TLSConfig != nil
+	for _, ln := range lns {
+		go func() {
+			var err error
+			if useTLS {
+				err = srv.ServeTLS(ln, "", "")
+			} else {
+				err = srv.Serve(ln)
+			}
+			if err != nil && err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	// The tunnel may go down with the ssh connection, which does not end
+	// the share. Its link has the local scheme, so it serves the same.
+	if tunnelLn != nil {
+		go func() {
+			var err error
+			if useTLS {
+				err = srv.ServeTLS(tunnelLn, "", "")
+			} else {
+				err = srv.Serve(tunnelLn)
+			}
+			if err != nil && err != http.ErrServerClosed {
+				log.Printf("tunnel: %v", err)
+			}
+		}()
+	}
+
+	if funnelLn != nil {
+		go func() {
+			if err := srv.Serve(funnelLn); err != nil && err != http.ErrServerClosed {
+				log.Printf("funnel: %v", err)
+			}
+		}()
+	}
+
+	started := qrLink
+	if started == "" && len(links) > 0 {
+		started = links[0]
+	}
+	emit(event{Event: "started", Link: started, Text: "sharing at " + started})
+
+	// The dashboard takes over the terminal and the log until the end.
+	dashDone := make(chan struct{})
+	if dash != nil {
+		logOut := log.Writer()
+		log.SetOutput(dash)
+		go func() {
+			dash.run(ctx, os.Stdout)
+			log.SetOutput(logOut)
+			close(dashDone)
+		}()
+	} else {
+		close(dashDone)
+	}
+
 	// Wait for context to be done (timeout or interrupt)
 	<-ctx.Done()
+	<-dashDone
+	// Give the mdns and ssdp goodbyes a chance to go out.
+	<-mdnsDone
+	<-ssdpDone
 
-	// Create a context for graceful shutdown
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	// Create a context for graceful shutdown, which lets transfers in
+	// progress finish.
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *drainWait)
 	defer cancel()
+	if n := conns.count(); n > 0 {
+		log.Printf("waiting up to %s for %d transfers to finish, interrupt again to stop now", *drainWait, n)
+	}
+	go func() {
+		select {
+		case <-forceStop:
+			cancel()
+		case <-shutdownCtx.Done():
+		}
+	}()
 
 	// Attempt graceful shutdown
+	if h3 != nil {
+		go h3.Shutdown(shutdownCtx)
+	}
 	if err := srv.Shutdown(shutdownCtx); err != nil {
-		log.Printf("Server forced to shutdown: %v", err)
+		log.Printf("Server forced to shutdown: %v, cutting off %d transfers", err, conns.count())
+		srv.Close()
 	} else {
 		log.Println("Server gracefully stopped")
 	}
+	if mapping != nil {
+		mapping.close()
+	}
+	if node != nil {
+		node.close()
+	}
+	if onionSvc != nil {
+		onionSvc.close()
+	}
+	if spans != nil {
+		spans.close()
+	}
+	summary := counter.summary(stats)
+	summary.print()
+	if summaryOut != nil {
+		if err := summary.write(summaryOut); err != nil {
+			log.Printf("-summary-out: %v", err)
+		}
+	}
+	downloads, sent := stats.totals()
+	emit(event{
+		Event: "shutdown",
+		Bytes: sent,
+		Text:  fmt.Sprintf("share stopped after %d downloads, %s sent", downloads, formatSize(sent)),
+	})
+	if hook != nil {
+		hook.close(5 * time.Second)
+	}
 }
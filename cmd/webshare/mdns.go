@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/ipv4"
+)
+
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// mdnsResponder advertises the share as DNS-SD services via multicast DNS,
+// answering queries for them and announcing them on start and shutdown.
+type mdnsResponder struct {
+	conn     *ipv4.PacketConn
+	ifaces   []net.Interface
+	services []dnsmessage.Name
+	instance string
+	host     dnsmessage.Name
+	port     uint16
+	ips      []net.IP
+	txt      []string
+}
+
+// newMDNS opens the multicast socket for advertising services, e.g.
+// _http._tcp, on port of the addresses in ips. The instance is named after
+// the host, e.g. "webshare on laptop".
+func newMDNS(services []string, port int, ips []net.IP, txt []string) (*mdnsResponder, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	hostname, _, _ = strings.Cut(hostname, ".")
+	conn, ifaces, err := listenMulticast(mdnsGroup)
+	if err != nil {
+		return nil, err
+	}
+	m := &mdnsResponder{
+		conn:     conn,
+		ifaces:   ifaces,
+		instance: "webshare on " + hostname,
+		host:     dnsmessage.MustNewName(hostname + ".local."),
+		port:     uint16(port),
+		ips:      ips,
+		txt:      txt,
+	}
+	for _, s := range services {
+		m.services = append(m.services, dnsmessage.MustNewName(s+".local."))
+	}
+	return m, nil
+}
+
+// instanceName returns the name of the instance of service.
+func (m *mdnsResponder) instanceName(service dnsmessage.Name) dnsmessage.Name {
+	// Dots would separate labels, they are not escaped by dnsmessage.
+	return dnsmessage.MustNewName(strings.ReplaceAll(m.instance, ".", "-") + "." + service.String())
+}
+
+// records returns the records answering q, or all of them for a nil q. The
+// records are given ttl, zero to say goodbye.
+func (m *mdnsResponder) records(q *dnsmessage.Question, ttl uint32) []dnsmessage.Resource {
+	match := func(name dnsmessage.Name, typ dnsmessage.Type) bool {
+		if q == nil {
+			return true
+		}
+		return strings.EqualFold(q.Name.String(), name.String()) && (q.Type == typ || q.Type == dnsmessage.TypeALL)
+	}
+	// Records only this host has set the cache flush bit in the class.
+	unique := dnsmessage.ClassINET | 1<<15
+	var rs []dnsmessage.Resource
+	add := func(name dnsmessage.Name, typ dnsmessage.Type, class dnsmessage.Class, body dnsmessage.ResourceBody) {
+		h := dnsmessage.ResourceHeader{Name: name, Type: typ, Class: class, TTL: ttl}
+		if match(name, typ) {
+			rs = append(rs, dnsmessage.Resource{Header: h, Body: body})
+		}
+	}
+	enum := dnsmessage.MustNewName("_services._dns-sd._udp.local.")
+	for _, s := range m.services {
+		instance := m.instanceName(s)
+		add(enum, dnsmessage.TypePTR, dnsmessage.ClassINET, &dnsmessage.PTRResource{PTR: s})
+		add(s, dnsmessage.TypePTR, dnsmessage.ClassINET, &dnsmessage.PTRResource{PTR: instance})
+		add(instance, dnsmessage.TypeSRV, unique, &dnsmessage.SRVResource{Target: m.host, Port: m.port})
+		add(instance, dnsmessage.TypeTXT, unique, &dnsmessage.TXTResource{TXT: append([]string{"txtvers=1"}, m.txt...)})
+	}
+	for _, ip := range m.ips {
+		var a dnsmessage.AResource
+		copy(a.A[:], ip.To4())
+		add(m.host, dnsmessage.TypeA, unique, &a)
+	}
+	return rs
+}
+
+// send writes msg as a response to addr, out of the interface with index
+// ifIndex or the default one for 0.
+func (m *mdnsResponder) send(msg dnsmessage.Message, addr net.Addr, ifIndex int) error {
+	msg.Response, msg.Authoritative = true, true
+	b, err := msg.Pack()
+	if err != nil {
+		return err
+	}
+	var cm *ipv4.ControlMessage
+	if ifIndex != 0 {
+		cm = &ipv4.ControlMessage{IfIndex: ifIndex}
+	}
+	_, err = m.conn.WriteTo(b, cm, addr)
+	return err
+}
+
+// announce sends all records with ttl on every interface.
+func (m *mdnsResponder) announce(ttl uint32) {
+	rs := m.records(nil, ttl)
+	if len(m.ifaces) == 0 {
+		m.send(dnsmessage.Message{Answers: rs}, mdnsGroup, 0)
+	}
+	for _, ifi := range m.ifaces {
+		m.conn.SetMulticastInterface(&ifi)
+		m.send(dnsmessage.Message{Answers: rs}, mdnsGroup, ifi.Index)
+	}
+}
+
+// serve answers queries until ctx is done, then says goodbye and closes the
+// socket.
+func (m *mdnsResponder) serve(ctx context.Context) {
+	go func() {
+		m.announce(120)
+		// Announcements are repeated once, as packets get lost.
+		select {
+		case <-time.After(time.Second):
+			m.announce(120)
+		case <-ctx.Done():
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		m.announce(0)
+		m.conn.Close()
+	}()
+	buf := make([]byte, 9000)
+	for {
+		n, cm, from, err := m.conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("mdns: %v", err)
+			}
+			return
+		}
+		var p dnsmessage.Parser
+		h, err := p.Start(buf[:n])
+		if err != nil || h.Response {
+			continue
+		}
+		qs, err := p.AllQuestions()
+		if err != nil {
+			continue
+		}
+		var msg dnsmessage.Message
+		unicast := false
+		for _, q := range qs {
+			// The top bit of the class asks for a unicast response.
+			if q.Class&(1<<15) != 0 {
+				unicast = true
+				q.Class &^= 1 << 15
+			}
+			msg.Answers = append(msg.Answers, m.records(&q, 120)...)
+		}
+		if len(msg.Answers) == 0 {
+			continue
+		}
+		// Simple resolvers not on port 5353 expect a plain DNS reply.
+		var to net.Addr = mdnsGroup
+		if addr, ok := from.(*net.UDPAddr); ok && addr.Port != mdnsGroup.Port {
+			msg.ID, msg.Questions, to = h.ID, qs, addr
+		} else if ok && unicast {
+			to = addr
+		}
+		ifIndex := 0
+		if cm != nil {
+			ifIndex = cm.IfIndex
+		}
+		if err := m.send(msg, to, ifIndex); err != nil {
+			log.Printf("mdns: %v", err)
+		}
+	}
+}
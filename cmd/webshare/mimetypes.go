@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"mime"
+	"os"
+	"strings"
+)
+
+// addMimeTypes registers overrides given as comma separated ext=type pairs,
+// e.g. ".log=text/plain,.m3u8=application/vnd.apple.mpegurl".
+func addMimeTypes(spec string) error {
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		ext, typ, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("invalid mime mapping %q, want .ext=type", pair)
+		}
+		if err := addMimeType(ext, typ); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addMimeTypesFile registers overrides from a file in the format of
+// /etc/mime.types: a type followed by one or more extensions per line.
+func addMimeTypesFile(fn string) error {
+	f, err := os.Open(fn)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		for _, ext := range fields[1:] {
+			if err := addMimeType(ext, fields[0]); err != nil {
+				return fmt.Errorf("%s: %w", fn, err)
+			}
+		}
+	}
+	return sc.Err()
+}
+
+func addMimeType(ext, typ string) error {
+	ext = strings.TrimSpace(ext)
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	return mime.AddExtensionType(ext, strings.TrimSpace(typ))
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// mount shares dir under the URL path prefix, which is empty for the root.
+type mount struct {
+	dir    string
+	prefix string
+}
+
+// mountFlag collects repeated -d flags of the form dir or dir:/prefix.
+type mountFlag []mount
+
+func (f *mountFlag) String() string {
+	var parts []string
+	for _, m := range *f {
+		if m.prefix == "" {
+			parts = append(parts, m.dir)
+		} else {
+			parts = append(parts, m.dir+":"+m.prefix)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f *mountFlag) Set(v string) error {
+	m := mount{dir: v}
+	// The prefix has to start with a slash and the colon of a windows
+	// drive letter, as in C:/Users, does not start one.
+	skip := 0
+	if len(v) >= 2 && v[1] == ':' && unicode.IsLetter(rune(v[0])) {
+		skip = 2
+	}
+	if i := strings.LastIndex(v[skip:], ":/"); i > 0 {
+		m.dir = v[:skip+i]
+		m.prefix = cleanPrefix(v[skip+i+1:])
+	}
+	return f.add(m)
+}
+
+func (f *mountFlag) add(m mount) error {
+	if routeTaken(m.prefix) {
+		return fmt.Errorf("%q is already routed", m.prefix+"/")
+	}
+	*f = append(*f, m)
+	return nil
+}
+
+// cleanPrefix normalizes a URL path prefix to start and not end with a
+// slash, with the empty string standing for the root.
+func cleanPrefix(p string) string {
+	return strings.TrimSuffix(path.Clean("/"+p), "/")
+}
+
+// vhost shares dir at the root of requests for host.
+type vhost struct {
+	host string
+	dir  string
+}
+
+// vhostFlag collects repeated -vhost host=dir flags.
+type vhostFlag []vhost
+
+func (f *vhostFlag) String() string {
+	var parts []string
+	for _, v := range *f {
+		parts = append(parts, v.host+"="+v.dir)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f *vhostFlag) Set(v string) error {
+	host, dir, ok := strings.Cut(v, "=")
+	if !ok || host == "" || dir == "" || strings.ContainsAny(host, "/:") {
+		return fmt.Errorf("invalid vhost %q, want host=dir, e.g. docs.local=./docs", v)
+	}
+	*f = append(*f, vhost{host: strings.ToLower(host), dir: dir})
+	return nil
+}
+
+// vhostHandler lowercases the Host header before passing requests on to the
+// mux, since host names are case insensitive but mux patterns are not.
+func vhostHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Host = strings.ToLower(r.Host)
+		h.ServeHTTP(w, r)
+	})
+}
+
+type mountPrefixKey struct{}
+
+// mountHandler serves h below prefix, with the prefix removed from request
+// paths and kept in the request context instead. Mounts can be nested, the
+// context then holds the combined prefix. Absolute redirects written by h
+// are relative to the mount, so the prefix is added to them.
+func mountHandler(prefix string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := strings.TrimPrefix(r.URL.Path, prefix)
+		if p == r.URL.Path || (p != "" && !strings.HasPrefix(p, "/")) {
+			http.NotFound(w, r)
+			return
+		}
+		if p == "" {
+			http.Redirect(w, r, prefix+"/", http.StatusMovedPermanently)
+			return
+		}
+		r2 := r.WithContext(context.WithValue(r.Context(), mountPrefixKey{}, mountPrefix(r)+prefix))
+		u := *r.URL
+		u.Path, u.RawPath = p, ""
+		r2.URL = &u
+		h.ServeHTTP(&prefixWriter{ResponseWriter: w, prefix: prefix}, r2)
+	})
+}
+
+// prefixWriter puts prefix in front of absolute paths in the Location header.
+type prefixWriter struct {
+	http.ResponseWriter
+	prefix      string
+	wroteHeader bool
+}
+
+func (pw *prefixWriter) WriteHeader(code int) {
+	if !pw.wroteHeader {
+		pw.wroteHeader = true
+		if loc := pw.Header().Get("Location"); strings.HasPrefix(loc, "/") && !strings.HasPrefix(loc, "//") {
+			pw.Header().Set("Location", pw.prefix+loc)
+		}
+	}
+	pw.ResponseWriter.WriteHeader(code)
+}
+
+func (pw *prefixWriter) Write(b []byte) (int, error) {
+	if !pw.wroteHeader {
+		pw.WriteHeader(http.StatusOK)
+	}
+	return pw.ResponseWriter.Write(b)
+}
+
+func (pw *prefixWriter) ReadFrom(r io.Reader) (int64, error) {
+	if !pw.wroteHeader {
+		pw.WriteHeader(http.StatusOK)
+	}
+	if rf, ok := pw.ResponseWriter.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(pw.ResponseWriter, r)
+}
+
+func (pw *prefixWriter) Flush() {
+	if f, ok := pw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (pw *prefixWriter) Unwrap() http.ResponseWriter {
+	return pw.ResponseWriter
+}
+
+// mountPrefix returns the prefix the request was mounted under, if any.
+// Handlers that write absolute links put it in front of them.
+func mountPrefix(r *http.Request) string {
+	prefix, _ := r.Context().Value(mountPrefixKey{}).(string)
+	return prefix
+}
+
+// mountRootHandler lists the mount points of ms at the root, for when no
+// directory is shared there.
+func mountRootHandler(ms mountFlag) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		var infos []fs.FileInfo
+		for _, m := range ms {
+			info := dirInfo{name: strings.TrimPrefix(m.prefix, "/"), modTime: time.Now()}
+			if fi, err := os.Stat(m.dir); err == nil {
+				info.modTime = fi.ModTime()
+			}
+			infos = append(infos, info)
+		}
+		sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+		renderListing(w, listingData{Path: "/"}, infos)
+	})
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// certAuthority issues the server and client certificates for -mtls. It is
+// kept in the configuration directory, so client certificates that were
+// installed once keep working across restarts.
+type certAuthority struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// loadCA reads the certificate authority from the configuration directory,
+// generating a new one on first use.
+func loadCA() (*certAuthority, error) {
+	certFile, err := configFile("mtls_ca.pem")
+	if err != nil {
+		return nil, err
+	}
+	keyFile, err := configFile("mtls_ca.key")
+	if err != nil {
+		return nil, err
+	}
+	if pair, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil {
+		key, ok := pair.PrivateKey.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%s: not an ecdsa key", keyFile)
+		}
+		cert, err := x509.ParseCertificate(pair.Certificate[0])
+		if err != nil {
+			return nil, err
+		}
+		return &certAuthority{cert: cert, key: key}, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	ca := &certAuthority{}
+	if ca.key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber(),
+		Subject:               pkix.Name{CommonName: "webshare CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &ca.key.PublicKey, ca.key)
+	if err != nil {
+		return nil, err
+	}
+	if ca.cert, err = x509.ParseCertificate(der); err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(ca.key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(certFile), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return nil, err
+	}
+	log.Printf("generated client certificate authority at %s", certFile)
+	return ca, nil
+}
+
+// issue signs a certificate for a new key from template.
+func (ca *certAuthority) issue(template *x509.Certificate) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	template.SerialNumber = serialNumber()
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().AddDate(1, 0, 0)
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	return cert, key, err
+}
+
+// serverCert issues a certificate for the local host names and addresses.
+func (ca *certAuthority) serverCert() (tls.Certificate, error) {
+	names, ips := localNames()
+	cert, key, err := ca.issue(&x509.Certificate{
+		Subject:     pkix.Name{CommonName: "webshare"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:    names,
+		IPAddresses: ips,
+	})
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{cert.Raw, ca.cert.Raw}, PrivateKey: key, Leaf: cert}, nil
+}
+
+// clientBundle issues a client certificate and returns it as a PKCS#12
+// bundle along with the CA, protected by password.
+func (ca *certAuthority) clientBundle(password string) ([]byte, error) {
+	name, _ := os.Hostname()
+	cert, key, err := ca.issue(&x509.Certificate{
+		Subject:     pkix.Name{CommonName: "webshare client " + name},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return nil, err
+	}
+	// The legacy encryption is the one phones and browsers can all import.
+	return pkcs12.LegacyDES.Encode(key, cert, []*x509.Certificate{ca.cert}, password)
+}
+
+// tlsConfig requests client certificates signed by the CA. Missing ones are
+// only rejected by Handler, so the bundle can be downloaded without one.
+func (ca *certAuthority) tlsConfig() (*tls.Config, error) {
+	cert, err := ca.serverCert()
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	}, nil
+}
+
+// bundleDownload serves a client certificate bundle at a secret path, once.
+type bundleDownload struct {
+	path   string
+	bundle []byte
+
+	mu    sync.Mutex
+	spent bool
+}
+
+// Handler wraps h and rejects requests without a verified client certificate,
+// except for the first request for the bundle.
+func (d *bundleDownload) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if r.URL.Path == d.path {
+			d.mu.Lock()
+			spent := d.spent
+			d.spent = true
+			d.mu.Unlock()
+			if !spent {
+				logRequest(r, "%s downloaded the client certificate", r.RemoteAddr)
+				w.Header().Set("Content-Type", "application/x-pkcs12")
+				w.Header().Set("Content-Disposition", `attachment; filename="webshare.p12"`)
+				w.Write(d.bundle)
+				return
+			}
+		}
+		http.Error(w, "client certificate required", http.StatusForbidden)
+	})
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+)
+
+// toastScript shows a windows toast notification with the title and body
+// from the environment, which also reaches powershell.exe from WSL via
+// WSLENV.
+const toastScript = `[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] > $null
+$xml = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$text = $xml.GetElementsByTagName('text')
+$text.Item(0).AppendChild($xml.CreateTextNode($env:WEBSHARE_TITLE)) > $null
+$text.Item(1).AppendChild($xml.CreateTextNode($env:WEBSHARE_BODY)) > $null
+$toast = [Windows.UI.Notifications.ToastNotification]::new($xml)
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('webshare').Show($toast)`
+
+// notifyCommands show a desktop notification, in the order they are
+// tried.
+var notifyCommands = []struct {
+	name string
+	cmd  func(title, body string) *exec.Cmd
+}{
+	{"notify-send", func(title, body string) *exec.Cmd {
+		return exec.Command("notify-send", "--app-name=webshare", title, body)
+	}},
+	{"osascript", func(title, body string) *exec.Cmd {
+		// The texts are passed as arguments, which need no quoting.
+		return exec.Command("osascript", "-e", "on run argv", "-e", "display notification (item 2 of argv) with title (item 1 of argv)", "-e", "end run", title, body)
+	}},
+	{"powershell.exe", func(title, body string) *exec.Cmd {
+		cmd := exec.Command("powershell.exe", "-NoProfile", "-NonInteractive", "-Command", toastScript)
+		cmd.Env = append(os.Environ(), "WEBSHARE_TITLE="+title, "WEBSHARE_BODY="+body, "WSLENV=WEBSHARE_TITLE:WEBSHARE_BODY")
+		return cmd
+	}},
+}
+
+// desktopNotifier returns an event sink showing downloads and uploads as
+// desktop notifications, with the first of notifyCommands found.
+func desktopNotifier() (func(event), error) {
+	for _, c := range notifyCommands {
+		if _, err := exec.LookPath(c.name); err != nil {
+			continue
+		}
+		return func(e event) {
+			if e.Event != "downloaded" && e.Event != "uploaded" {
+				return
+			}
+			go func() {
+				if out, err := c.cmd("webshare", e.Text).CombinedOutput(); err != nil {
+					log.Printf("notify: %s: %v %s", c.name, err, out)
+				}
+			}()
+		}, nil
+	}
+	return nil, errors.New("no notification command found, install notify-send")
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/textproto"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// onionService publishes the share as a tor onion service through the
+// control port of a running tor, or of one started for it. The service is
+// removed by tor when the control connection closes.
+type onionService struct {
+	conn *textproto.Conn
+	cmd  *exec.Cmd
+	// host is the .onion name of the service.
+	host string
+}
+
+// publishOnion adds an onion service forwarding port to local. The key is
+// kept in the user config directory, so the address stays the same.
+func publishOnion(ctx context.Context, port int, local *url.URL) (*onionService, error) {
+	o := &onionService{}
+	addr := os.Getenv("TOR_CONTROL")
+	if addr == "" {
+		addr = "127.0.0.1:9051"
+	}
+	c, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		if c, err = o.startTor(ctx); err != nil {
+			return nil, err
+		}
+	}
+	o.conn = textproto.NewConn(c)
+	if err := o.authenticate(); err != nil {
+		o.close()
+		return nil, err
+	}
+	fn, err := configFile("onion_key")
+	if err != nil {
+		o.close()
+		return nil, err
+	}
+	key := "NEW:ED25519-V3"
+	if b, err := os.ReadFile(fn); err == nil {
+		key = strings.TrimSpace(string(b))
+	}
+	lines, err := o.call(fmt.Sprintf("ADD_ONION %s Port=%d,%s", key, port, local.Host))
+	if err != nil {
+		o.close()
+		return nil, err
+	}
+	for _, line := range lines {
+		if v, ok := strings.CutPrefix(line, "ServiceID="); ok {
+			o.host = v + ".onion"
+		} else if v, ok := strings.CutPrefix(line, "PrivateKey="); ok {
+			if err := os.MkdirAll(filepath.Dir(fn), 0700); err == nil {
+				err = os.WriteFile(fn, []byte(v+"\n"), 0600)
+			}
+			if err != nil {
+				log.Printf("saving onion key: %v", err)
+			}
+		}
+	}
+	if o.host == "" {
+		o.close()
+		return nil, errors.New("no service id from tor")
+	}
+	return o, nil
+}
+
+// startTor runs tor with a control port and a temporary data directory and
+// connects to it.
+func (o *onionService) startTor(ctx context.Context) (net.Conn, error) {
+	dir, err := os.MkdirTemp("", "webshare-tor")
+	if err != nil {
+		return nil, err
+	}
+	portFile := filepath.Join(dir, "control-port")
+	o.cmd = exec.CommandContext(ctx, "tor",
+		"--DataDirectory", dir,
+		"--SocksPort", "0",
+		"--ControlPort", "auto",
+		"--ControlPortWriteToFile", portFile,
+		"--CookieAuthentication", "1")
+	o.cmd.Cancel = func() error {
+		defer os.RemoveAll(dir)
+		return o.cmd.Process.Kill()
+	}
+	if err := o.cmd.Start(); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("no tor control port and %w", err)
+	}
+	log.Printf("started tor, pid %d", o.cmd.Process.Pid)
+	for range 300 {
+		// The file has a line like PORT=127.0.0.1:41223.
+		if b, err := os.ReadFile(portFile); err == nil && strings.HasSuffix(string(b), "\n") {
+			addr := strings.TrimPrefix(strings.TrimSpace(string(b)), "PORT=")
+			return net.Dial("tcp", addr)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	o.cmd.Cancel()
+	o.cmd.Wait()
+	return nil, errors.New("tor did not open its control port")
+}
+
+// authenticate logs in with the cookie file or without credentials, as
+// offered by tor.
+func (o *onionService) authenticate() error {
+	lines, err := o.call("PROTOCOLINFO 1")
+	if err != nil {
+		return err
+	}
+	var methods, cookieFile string
+	for _, line := range lines {
+		if v, ok := strings.CutPrefix(line, "AUTH "); ok {
+			for _, f := range strings.Fields(v) {
+				if m, ok := strings.CutPrefix(f, "METHODS="); ok {
+					methods = m
+				} else if fn, ok := strings.CutPrefix(f, "COOKIEFILE="); ok {
+					cookieFile = strings.Trim(fn, `"`)
+				}
+			}
+		}
+	}
+	switch {
+	case strings.Contains(methods, "NULL"):
+		_, err = o.call("AUTHENTICATE")
+	case strings.Contains(methods, "COOKIE") && cookieFile != "":
+		var cookie []byte
+		if cookie, err = os.ReadFile(cookieFile); err != nil {
+			return err
+		}
+		_, err = o.call("AUTHENTICATE " + hex.EncodeToString(cookie))
+	default:
+		return fmt.Errorf("unsupported tor authentication %s", methods)
+	}
+	return err
+}
+
+// call sends a command and returns the lines of a successful reply
+// without the status codes.
+func (o *onionService) call(cmd string) ([]string, error) {
+	if err := o.conn.PrintfLine("%s", cmd); err != nil {
+		return nil, err
+	}
+	var lines []string
+	for {
+		line, err := o.conn.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) < 4 {
+			return nil, fmt.Errorf("invalid reply %q", line)
+		}
+		if line[0] != '2' {
+			return nil, fmt.Errorf("tor: %s", line)
+		}
+		lines = append(lines, line[4:])
+		if line[3] == ' ' {
+			return lines, nil
+		}
+	}
+}
+
+// close removes the service and stops tor if it was started for it.
+func (o *onionService) close() {
+	if o.conn != nil {
+		o.conn.Close()
+	}
+	if o.cmd != nil {
+		o.cmd.Cancel()
+		o.cmd.Wait()
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// pickerTimeout is how long pickLink waits for a choice, so an unattended
+// start still serves.
+const pickerTimeout = 15 * time.Second
+
+// canPick reports whether a picker can be shown on the terminal.
+func canPick() bool {
+	return term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// pickLink lets the user choose one of links with the arrow keys and enter,
+// starting at def. It returns the current link if no key is pressed within
+// pickerTimeout, def if the picker is left with escape or q.
+func pickLink(links []string, def int) int {
+	fd := int(os.Stdin.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return def
+	}
+	defer term.Restore(fd, state)
+	keys := make(chan []byte)
+	go func() {
+		// Left blocked on timeout, nothing else reads the terminal.
+		for {
+			buf := make([]byte, 8)
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				close(keys)
+				return
+			}
+			keys <- buf[:n]
+		}
+	}()
+	cur := def
+	draw := func(redraw bool) {
+		if redraw {
+			fmt.Printf("\x1b[%dA", len(links)+1)
+		}
+		fmt.Printf("\r\x1b[Kchoose the link for the qr code with the arrow keys and enter:\r\n")
+		for i, link := range links {
+			if i == cur {
+				fmt.Printf("\r\x1b[K\x1b[7m> %s\x1b[0m\r\n", link)
+			} else {
+				fmt.Printf("\r\x1b[K  %s\r\n", link)
+			}
+		}
+	}
+	draw(false)
+	timeout := time.After(pickerTimeout)
+	for {
+		select {
+		case key, ok := <-keys:
+			if !ok {
+				return cur
+			}
+			switch string(key) {
+			case "\x1b[A", "k":
+				cur = (cur + len(links) - 1) % len(links)
+			case "\x1b[B", "j":
+				cur = (cur + 1) % len(links)
+			case "\r", "\n":
+				return cur
+			case "\x1b", "q":
+				return def
+			case "\x03":
+				term.Restore(fd, state)
+				os.Exit(130)
+			}
+			draw(true)
+			timeout = time.After(pickerTimeout)
+		case <-timeout:
+			return cur
+		}
+	}
+}
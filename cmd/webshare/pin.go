@@ -0,0 +1,55 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	_ "embed"
+	"encoding/hex"
+	"html/template"
+	"net/http"
+	"net/url"
+)
+
+// pinPath serves the certificate check page of -pin.
+const pinPath = "/_/pin"
+
+//go:embed assets/pin.html
+var pinPage string
+
+var pinTemplate = template.Must(template.New("pin").Parse(pinPage))
+
+// certPin lets recipients of a link check that they reached the server
+// with the certificate the link was made for. The hash of the certificate
+// goes into the fragment of printed links, which browsers do not send, and
+// a page on the server compares it with the certificate it serves.
+type certPin struct {
+	cert *x509.Certificate
+}
+
+// hash returns the SHA-256 hash of the certificate in lowercase hex.
+func (p *certPin) hash() string {
+	sum := sha256.Sum256(p.cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// link returns the link to the check page on base, continuing to next.
+func (p *certPin) link(base, next string) string {
+	q := url.Values{}
+	q.Set("sha256", p.hash())
+	q.Set("next", next)
+	return base + pinPath + "#" + q.Encode()
+}
+
+// Handler wraps h and serves the check page, which does not need the token
+// or any other credentials.
+func (p *certPin) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != pinPath {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		pinTemplate.Execute(w, struct{ Fingerprint, Hash string }{fingerprint(p.cert), p.hash()})
+	})
+}
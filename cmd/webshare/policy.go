@@ -0,0 +1,304 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// sensitiveNames are dotfiles that are never served unless all hidden files
+// are explicitly shown, since they tend to contain secrets or history.
+var sensitiveNames = map[string]bool{
+	".git":          true,
+	".hg":           true,
+	".svn":          true,
+	".env":          true,
+	".ssh":          true,
+	".gnupg":        true,
+	".aws":          true,
+	".docker":       true,
+	".kube":         true,
+	".netrc":        true,
+	".npmrc":        true,
+	".pypirc":       true,
+	".htpasswd":     true,
+	".bash_history": true,
+	".zsh_history":  true,
+}
+
+func isSensitive(name string) bool {
+	return sensitiveNames[name] || strings.HasPrefix(name, ".env.")
+}
+
+// pathPolicy decides which paths of the share show up in listings and which
+// can be accessed at all. Paths are slash separated and relative to the
+// shared directory.
+type pathPolicy struct {
+	// mu guards hidden, include and exclude, which are reloaded on SIGHUP.
+	mu sync.RWMutex
+	// hidden controls dotfiles: "show" lists and serves them, "hide" keeps
+	// them out of listings and denies the sensitive ones, "deny" denies them
+	// all.
+	hidden string
+	// include, if not empty, restricts the files of the share to those
+	// matching one of the patterns. Directories are not affected, so the
+	// matching files can still be reached.
+	include globList
+	// exclude denies matching paths along with everything below them.
+	exclude globList
+	// symlinks controls which links are followed: "never", "inside" for
+	// those whose target stays within the shared directory, or "always".
+	symlinks string
+}
+
+// policy applies to every way of accessing the share.
+var policy = &pathPolicy{hidden: "hide", symlinks: "inside"}
+
+func (p *pathPolicy) setHidden(v string) error {
+	switch v {
+	case "show", "hide", "deny":
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.hidden = v
+		return nil
+	}
+	return fmt.Errorf("invalid -hidden value %q, want show, hide or deny", v)
+}
+
+func (p *pathPolicy) setSymlinks(v string) error {
+	switch v {
+	case "never", "inside", "always":
+		p.symlinks = v
+		return nil
+	}
+	return fmt.Errorf("invalid -follow-symlinks value %q, want never, inside or always", v)
+}
+
+// setRules replaces the hidden, include and exclude rules with those of
+// next.
+func (p *pathPolicy) setRules(next *pathPolicy) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.hidden, p.include, p.exclude = next.hidden, next.include, next.exclude
+}
+
+// rules returns the hidden, include and exclude rules.
+func (p *pathPolicy) rules() (string, globList, globList) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.hidden, p.include, p.exclude
+}
+
+// elements returns the names along the path name.
+func elements(name string) []string {
+	return strings.Split(strings.Trim(path.Clean("/"+name), "/"), "/")
+}
+
+func isDotfile(name string) bool {
+	return strings.HasPrefix(name, ".") && name != "." && name != ".."
+}
+
+// denied reports whether name or one of its parents is denied, regardless
+// of whether it is a file or a directory. Writes are checked with this.
+func (p *pathPolicy) denied(name string) bool {
+	hidden, _, exclude := p.rules()
+	elems := elements(name)
+	for i, e := range elems {
+		if isDotfile(e) {
+			switch hidden {
+			case "deny":
+				return true
+			case "hide":
+				if isSensitive(e) {
+					return true
+				}
+			}
+		}
+		if exclude.match(strings.Join(elems[:i+1], "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowed reports whether name, a directory if dir is set, may be read.
+func (p *pathPolicy) allowed(name string, dir bool) bool {
+	if p.denied(name) {
+		return false
+	}
+	_, include, _ := p.rules()
+	return dir || len(include) == 0 || include.match(strings.Trim(path.Clean("/"+name), "/"))
+}
+
+// listed reports whether name should appear in listings and archives.
+func (p *pathPolicy) listed(name string, dir bool) bool {
+	if !p.allowed(name, dir) {
+		return false
+	}
+	if hidden, _, _ := p.rules(); hidden != "show" {
+		for _, e := range elements(name) {
+			if isDotfile(e) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// followed reports whether the symlink policy allows reaching name below
+// dir. Paths that do not exist yet are judged by their closest existing
+// parent, so writes cannot go through a link either.
+func (p *pathPolicy) followed(dir, name string) bool {
+	switch p.symlinks {
+	case "always":
+		return true
+	case "never":
+		fn := dir
+		for _, e := range elements(name) {
+			if e == "" {
+				continue
+			}
+			fn = filepath.Join(fn, e)
+			fi, err := os.Lstat(fn)
+			if err != nil {
+				return true
+			}
+			if fi.Mode()&fs.ModeSymlink != 0 {
+				return false
+			}
+		}
+		return true
+	}
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return false
+	}
+	fn := localPath(dir, name)
+	for {
+		if real, err := filepath.EvalSymlinks(fn); err == nil {
+			rel, err := filepath.Rel(root, real)
+			return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+		}
+		if fi, err := os.Lstat(fn); err == nil && fi.Mode()&fs.ModeSymlink != 0 {
+			// A dangling link, which a write would create the target of.
+			return false
+		}
+		parent := filepath.Dir(fn)
+		if parent == fn {
+			return false
+		}
+		fn = parent
+	}
+}
+
+// stat is like os.Stat for name below dir, but fails with fs.ErrNotExist if
+// the policy does not allow reading name.
+func (p *pathPolicy) stat(dir, name string) (fs.FileInfo, error) {
+	fi, err := os.Stat(localPath(dir, name))
+	if err != nil {
+		return nil, err
+	}
+	if !p.allowed(name, fi.IsDir()) || !p.followed(dir, name) {
+		return nil, fs.ErrNotExist
+	}
+	return fi, nil
+}
+
+// listedEntry is like listed for the directory entry d found at name below
+// dir. Links are judged by their target.
+func (p *pathPolicy) listedEntry(dir, name string, d fs.DirEntry) bool {
+	if d.Type()&fs.ModeSymlink == 0 {
+		return p.listed(name, d.IsDir())
+	}
+	if !p.followed(dir, name) {
+		return false
+	}
+	fi, err := os.Stat(localPath(dir, name))
+	return p.listed(name, err == nil && fi.IsDir())
+}
+
+// globList collects repeated glob flags. Patterns without a slash match a
+// name at any depth, like in .gitignore, others match from the root of the
+// share. A "**" element matches any number of directories.
+type globList []string
+
+func (g *globList) String() string {
+	return strings.Join(*g, ",")
+}
+
+func (g *globList) Set(v string) error {
+	v = strings.Trim(v, "/")
+	for _, e := range strings.Split(v, "/") {
+		if _, err := path.Match(e, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", v, err)
+		}
+	}
+	*g = append(*g, v)
+	return nil
+}
+
+// match reports whether the relative slash path name matches any pattern.
+func (g globList) match(name string) bool {
+	elems := strings.Split(name, "/")
+	for _, pattern := range g {
+		if !strings.Contains(pattern, "/") {
+			pattern = "**/" + pattern
+		}
+		if matchElements(strings.Split(pattern, "/"), elems) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchElements(pattern, elems []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(elems); i++ {
+				if matchElements(pattern[1:], elems[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(elems) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], elems[0]); !ok {
+			return false
+		}
+		pattern, elems = pattern[1:], elems[1:]
+	}
+	return len(elems) == 0
+}
+
+// policyHandler wraps h and answers requests for paths below dir that are
+// not allowed as if they did not exist. Paths that do not exist yet, like
+// upload targets, are only checked against the rules for both kinds.
+func policyHandler(dir string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok := !policy.denied(r.URL.Path)
+		if fi, err := os.Stat(localPath(dir, r.URL.Path)); err == nil {
+			ok = policy.allowed(r.URL.Path, fi.IsDir())
+		}
+		if !ok || !policy.followed(dir, r.URL.Path) {
+			http.NotFound(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// skipEntry is returned from a WalkDir function to leave out d, along with
+// everything below it if it is a directory.
+func skipEntry(d fs.DirEntry) error {
+	if d.IsDir() {
+		return filepath.SkipDir
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+type credentials struct {
+	name string
+}
+
+// lookupUser is only implemented on unix systems.
+func lookupUser(name string) (*credentials, error) {
+	return nil, errors.New("-user is only supported on unix systems")
+}
+
+func (c *credentials) drop() error {
+	return nil
+}
+
+// privateDir is only checked on unix systems, where the user cache
+// directory could be shared.
+func privateDir(fi os.FileInfo) bool {
+	return true
+}
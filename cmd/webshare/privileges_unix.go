@@ -0,0 +1,58 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// privateDir reports whether fi is owned by this user and not writable by
+// others.
+func privateDir(fi os.FileInfo) bool {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	return ok && int(st.Uid) == os.Getuid() && fi.Mode().Perm()&0022 == 0
+}
+
+// credentials are the user and group ids to switch to after binding.
+type credentials struct {
+	name     string
+	uid, gid int
+}
+
+// lookupUser finds the ids of the user name, or of a numeric user id. It
+// has to run before entering the jail, where /etc/passwd is gone.
+func lookupUser(name string) (*credentials, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		if u, err = user.LookupId(name); err != nil {
+			return nil, fmt.Errorf("-user: unknown user %q", name)
+		}
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return nil, err
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return nil, err
+	}
+	return &credentials{name: u.Username, uid: uid, gid: gid}, nil
+}
+
+// drop switches the process to c for good, supplementary groups included.
+func (c *credentials) drop() error {
+	if err := syscall.Setgroups([]int{c.gid}); err != nil {
+		return fmt.Errorf("-user: setgroups: %w", err)
+	}
+	if err := syscall.Setgid(c.gid); err != nil {
+		return fmt.Errorf("-user: setgid: %w", err)
+	}
+	if err := syscall.Setuid(c.uid); err != nil {
+		return fmt.Errorf("-user: setuid: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+)
+
+// parseUpstream parses the URL of a server to proxy to.
+func parseUpstream(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid upstream %q, want e.g. http://localhost:8080", s)
+	}
+	return u, nil
+}
+
+// proxyHandler forwards requests to the server at target, so a local dev
+// server can be shared like a directory. The Host header is rewritten to the
+// target, since dev servers tend to reject unknown hosts, and responses are
+// flushed right away to keep event streams and live reload working.
+func proxyHandler(target *url.URL) http.Handler {
+	return &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(target)
+			r.SetXForwarded()
+		},
+		FlushInterval: -1,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			logRequest(r, "proxy %s: %v", r.URL.Path, err)
+			http.Error(w, "upstream unavailable", http.StatusBadGateway)
+		},
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// publicIP returns the address this host has on the internet, asking the
+// STUN server at via, or the https endpoint at via, which answers with the
+// address as text, like https://icanhazip.com.
+func publicIP(ctx context.Context, via string) (net.IP, error) {
+	if strings.HasPrefix(via, "https://") || strings.HasPrefix(via, "http://") {
+		return echoIP(ctx, via)
+	}
+	return stunIP(via)
+}
+
+func echoIP(ctx context.Context, endpoint string) (net.IP, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", endpoint, resp.Status)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 256))
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(strings.TrimSpace(string(b)))
+	if ip == nil {
+		return nil, fmt.Errorf("%s: no address in %q", endpoint, b)
+	}
+	return ip, nil
+}
+
+// stunMagicCookie is in every STUN message since RFC 5389.
+const stunMagicCookie = 0x2112A442
+
+// stunIP sends a STUN binding request to server and returns the mapped
+// address of the response.
+func stunIP(server string) (net.IP, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "3478")
+	}
+	conn, err := net.Dial("udp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	req := make([]byte, 20)
+	binary.BigEndian.PutUint16(req[0:], 0x0001) // binding request
+	binary.BigEndian.PutUint32(req[4:], stunMagicCookie)
+	rand.Read(req[8:20])
+	resp := make([]byte, 512)
+	wait := 500 * time.Millisecond
+	for range 3 {
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+		conn.SetReadDeadline(time.Now().Add(wait))
+		wait *= 2
+		n, err := conn.Read(resp)
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return nil, err
+		}
+		// A binding success response for the transaction.
+		if n < 20 || binary.BigEndian.Uint16(resp[0:]) != 0x0101 || string(resp[8:20]) != string(req[8:20]) {
+			continue
+		}
+		return stunMappedAddress(resp[20:n], req[4:20])
+	}
+	return nil, fmt.Errorf("%s: no response", server)
+}
+
+// stunMappedAddress returns the address in the XOR-MAPPED-ADDRESS or
+// MAPPED-ADDRESS attribute of attrs. The former is xored with the magic
+// cookie and transaction id in key.
+func stunMappedAddress(attrs, key []byte) (net.IP, error) {
+	var mapped net.IP
+	for len(attrs) >= 4 {
+		typ := binary.BigEndian.Uint16(attrs[0:])
+		size := int(binary.BigEndian.Uint16(attrs[2:]))
+		if len(attrs) < 4+size {
+			break
+		}
+		value := attrs[4 : 4+size]
+		// Family 1 is IPv4, 2 IPv6, after a reserved byte and the port.
+		if (typ == 0x0020 || typ == 0x0001) && size >= 8 {
+			n := 4
+			if value[1] == 2 {
+				n = 16
+			}
+			if len(value) >= 4+n {
+				ip := make(net.IP, n)
+				copy(ip, value[4:4+n])
+				if typ == 0x0020 {
+					for i := range ip {
+						ip[i] ^= key[i]
+					}
+					return ip, nil
+				}
+				mapped = ip
+			}
+		}
+		// Attributes are padded to four bytes.
+		next := 4 + (size+3)&^3
+		if next > len(attrs) {
+			break
+		}
+		attrs = attrs[next:]
+	}
+	if mapped == nil {
+		return nil, errors.New("no mapped address in the STUN response")
+	}
+	return mapped, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"rsc.io/qr"
+)
+
+// writeQR saves the qr code of text as a PNG or SVG image, by the extension
+// of fn.
+func writeQR(fn, text string) error {
+	code, err := qr.Encode(text, qr.M)
+	if err != nil {
+		return err
+	}
+	var b []byte
+	switch strings.ToLower(filepath.Ext(fn)) {
+	case ".png":
+		b = code.PNG()
+	case ".svg":
+		b = qrSVG(code)
+	default:
+		return fmt.Errorf("%s: only .png and .svg are supported", fn)
+	}
+	return os.WriteFile(fn, b, 0644)
+}
+
+// qrSVG draws the code as one path of unit squares with a quiet zone of
+// four modules.
+func qrSVG(code *qr.Code) []byte {
+	const quiet = 4
+	var buf bytes.Buffer
+	n := code.Size + 2*quiet
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" width="%d" height="%d" shape-rendering="crispEdges">`+"\n", n, n, n*8, n*8)
+	fmt.Fprintf(&buf, `<rect width="%d" height="%d" fill="#fff"/>`+"\n", n, n)
+	buf.WriteString(`<path fill="#000" d="`)
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; x++ {
+			if code.Black(x, y) {
+				fmt.Fprintf(&buf, "M%d %dh1v1h-1z", x+quiet, y+quiet)
+			}
+		}
+	}
+	buf.WriteString("\"/>\n</svg>\n")
+	return buf.Bytes()
+}
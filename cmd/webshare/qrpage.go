@@ -0,0 +1,67 @@
+package main
+
+import (
+	_ "embed"
+	"html/template"
+	"net/http"
+
+	"rsc.io/qr"
+)
+
+// qrPagePath serves a page with the qr code of the share link, the image
+// itself is at qrPagePath.png.
+const qrPagePath = "/_/qr"
+
+//go:embed assets/qr.html
+var qrPage string
+
+var qrTemplate = template.Must(template.New("qr").Parse(qrPage))
+
+// shareQR shows the share link as a qr code, for passing it on from a
+// device that has it open to one with a camera.
+type shareQR struct {
+	// path is the path of the share link below the token prefix, which is
+	// taken from the request as it can be rotated.
+	path string
+	pin  *certPin
+}
+
+// link returns the share link on the host the request was made for.
+func (q *shareQR) link(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	base := scheme + "://" + r.Host
+	p := mountPrefix(r) + q.path
+	if q.pin != nil {
+		return q.pin.link(base, p)
+	}
+	if p == "" {
+		return base + "/"
+	}
+	return base + p
+}
+
+// Handler wraps h and serves the qr code page and image.
+func (q *shareQR) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case qrPagePath:
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Set("Cache-Control", "no-store")
+			qrTemplate.Execute(w, q.link(r))
+		case qrPagePath + ".png":
+			code, err := qr.Encode(q.link(r), qr.M)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "image/png")
+			w.Header().Set("Cache-Control", "no-store")
+			w.Write(code.PNG())
+		default:
+			h.ServeHTTP(w, r)
+		}
+	})
+}
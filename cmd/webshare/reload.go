@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+// reloadedValues returns the values of the flag name in config, or its
+// default if config lacks it, and false if the command line or the
+// environment set it, which keeps it as it is.
+func reloadedValues(name string, config map[string][]string) ([]string, bool) {
+	if cmdlineFlags[name] {
+		return nil, false
+	}
+	if _, ok := os.LookupEnv(envName(name)); ok {
+		return nil, false
+	}
+	if v, ok := config[name]; ok {
+		return v, true
+	}
+	if def := flag.Lookup(name).DefValue; def != "" {
+		return []string{def}, true
+	}
+	return nil, true
+}
+
+// reloadPolicy reads the config file fn again and applies its -hidden,
+// -include and -exclude rules. Nothing changes if one of them is invalid.
+func reloadPolicy(fn string) error {
+	config, fn, err := loadConfig(fn)
+	if err != nil {
+		return err
+	}
+	next := &pathPolicy{}
+	next.hidden, next.include, next.exclude = policy.rules()
+	if v, ok := reloadedValues("hidden", config); ok {
+		if err := next.setHidden(v[len(v)-1]); err != nil {
+			return fmt.Errorf("%s: %w", fn, err)
+		}
+	}
+	for name, list := range map[string]*globList{"include": &next.include, "exclude": &next.exclude} {
+		v, ok := reloadedValues(name, config)
+		if !ok {
+			continue
+		}
+		*list = nil
+		for _, pattern := range v {
+			if err := list.Set(pattern); err != nil {
+				return fmt.Errorf("%s: %s: %w", fn, name, err)
+			}
+		}
+	}
+	policy.setRules(next)
+	log.Printf("reloaded %s: hidden files %s, %d include and %d exclude patterns", fn, next.hidden, len(next.include), len(next.exclude))
+	return nil
+}
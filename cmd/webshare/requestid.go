@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+type requestIDKey struct{}
+
+// maxRequestID is the longest X-Request-ID taken over from clients.
+const maxRequestID = 128
+
+// validRequestID reports whether an incoming X-Request-ID can go into log
+// lines as is.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestID {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-_.:+=/", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// requestIDHandler wraps h and gives every request an id, the
+// X-Request-ID of the client or a random one. It is sent back in the same
+// header, passed on to proxied servers and added to plain text error
+// responses, so reports of errors can be found in the log.
+func requestIDHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = randomHex(8)
+			r.Header.Set("X-Request-ID", id)
+		}
+		w.Header().Set("X-Request-ID", id)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+		rec := &responseRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r)
+		// Errors from http.Error have no length, so a line can be added.
+		hdr := rec.Header()
+		if rec.Status() >= 400 && rec.err == nil && r.Method != http.MethodHead &&
+			strings.HasPrefix(hdr.Get("Content-Type"), "text/plain") && hdr.Get("Content-Length") == "" {
+			fmt.Fprintf(rec, "request id %s\n", id)
+		}
+	})
+}
+
+// requestID returns the id of r, or an empty string outside of
+// requestIDHandler.
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// logRequest logs a line about r, starting with its id.
+func logRequest(r *http.Request, format string, v ...any) {
+	if id := requestID(r); id != "" {
+		format = "[" + id + "] " + format
+	}
+	log.Printf(format, v...)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// proxyRoute forwards requests below prefix to target, with the prefix kept
+// in the path.
+type proxyRoute struct {
+	prefix string
+	target *url.URL
+}
+
+// proxies are the upstream routes added with -route and -routes.
+var proxies []proxyRoute
+
+// routeTaken reports whether a directory or upstream is already routed at
+// prefix.
+func routeTaken(prefix string) bool {
+	for _, m := range mounts {
+		if m.prefix == prefix {
+			return true
+		}
+	}
+	for _, p := range proxies {
+		if p.prefix == prefix {
+			return true
+		}
+	}
+	return false
+}
+
+// shadowed reports whether the URL path p is routed below a longer prefix
+// than prefix, so a share at prefix must not register a handler for it.
+func shadowed(prefix, p string) bool {
+	below := func(other string) bool {
+		return len(other) > len(prefix) && strings.HasPrefix(p, other+"/")
+	}
+	for _, m := range mounts {
+		if below(m.prefix) {
+			return true
+		}
+	}
+	for _, r := range proxies {
+		if below(r.prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// addRoute routes requests below prefix to target, which is either an
+// upstream URL or a directory.
+func addRoute(prefix, target string) error {
+	prefix = cleanPrefix(prefix)
+	if !strings.Contains(target, "://") {
+		return mounts.add(mount{dir: target, prefix: prefix})
+	}
+	u, err := parseUpstream(target)
+	if err != nil {
+		return err
+	}
+	if routeTaken(prefix) {
+		return fmt.Errorf("%q is already routed", prefix+"/")
+	}
+	proxies = append(proxies, proxyRoute{prefix: prefix, target: u})
+	return nil
+}
+
+// routeFlag adds a route for each -route prefix=target flag.
+type routeFlag struct{}
+
+func (routeFlag) String() string { return "" }
+
+func (routeFlag) Set(v string) error {
+	prefix, target, ok := strings.Cut(v, "=")
+	if !ok || target == "" {
+		return fmt.Errorf("invalid route %q, want prefix=target, e.g. /api/=http://localhost:8080", v)
+	}
+	return addRoute(prefix, target)
+}
+
+// loadRoutes adds the routes of a file with one "prefix target" pair per
+// line. Empty lines and lines starting with # are ignored.
+func loadRoutes(fn string) error {
+	f, err := os.Open(fn)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return fmt.Errorf("%s:%d: want prefix and target", fn, n)
+		}
+		if err := addRoute(fields[0], fields[1]); err != nil {
+			return fmt.Errorf("%s:%d: %w", fn, n, err)
+		}
+	}
+	return sc.Err()
+}
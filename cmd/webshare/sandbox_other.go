@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// enterSandbox is only implemented on linux.
+func enterSandbox(ro, rw []string) error {
+	return errors.New("-sandbox is only supported on linux")
+}
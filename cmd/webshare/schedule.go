@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// window is the time of day the share is open, for -from, -until and
+// -days. It ends on the next day if until is not after from.
+type window struct {
+	// from and until are minutes since midnight.
+	from, until int
+	// days are the weekdays on which the window starts.
+	days [7]bool
+}
+
+// parseWindow parses the -from and -until times of day like 09:00, which
+// default to midnight, and the -days like mon-fri or sat,sun, which
+// default to every day.
+func parseWindow(from, until, days string) (*window, error) {
+	w := &window{}
+	var err error
+	if w.from, err = parseClock(from); err != nil {
+		return nil, fmt.Errorf("-from: %w", err)
+	}
+	if w.until, err = parseClock(until); err != nil {
+		return nil, fmt.Errorf("-until: %w", err)
+	}
+	if days == "" {
+		days = "sun-sat"
+	}
+	for _, part := range strings.Split(strings.ToLower(days), ",") {
+		first, last, isRange := strings.Cut(strings.TrimSpace(part), "-")
+		i, j := slices.Index(weekdays, first), slices.Index(weekdays, last)
+		if !isRange {
+			j = i
+		}
+		if i < 0 || j < 0 {
+			return nil, fmt.Errorf("-days: want days like mon-fri or sat,sun, got %q", part)
+		}
+		// Ranges like fri-mon wrap around the weekend.
+		for d := i; ; d = (d + 1) % 7 {
+			w.days[d] = true
+			if d == j {
+				break
+			}
+		}
+	}
+	return w, nil
+}
+
+// parseClock returns the minutes since midnight of a time of day like
+// 17:30, or 0 for an empty one.
+func parseClock(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	hh, mm, ok := strings.Cut(s, ":")
+	h, err1 := strconv.Atoi(hh)
+	m, err2 := strconv.Atoi(mm)
+	if !ok || err1 != nil || err2 != nil || h < 0 || h > 23 || m < 0 || m > 59 {
+		return 0, fmt.Errorf("want a time of day like 09:00, got %q", s)
+	}
+	return h*60 + m, nil
+}
+
+// span returns the window starting on the day of t plus day, and whether
+// it is open on that day at all.
+func (w *window) span(t time.Time, day int) (time.Time, time.Time, bool) {
+	y, m, d := t.Date()
+	start := time.Date(y, m, d+day, w.from/60, w.from%60, 0, 0, t.Location())
+	end := time.Date(y, m, d+day, w.until/60, w.until%60, 0, 0, t.Location())
+	if !end.After(start) {
+		end = time.Date(y, m, d+day+1, w.until/60, w.until%60, 0, 0, t.Location())
+	}
+	return start, end, w.days[start.Weekday()]
+}
+
+// open reports whether the share is open at t, in the window of that day
+// or one from the day before running overnight.
+func (w *window) open(t time.Time) bool {
+	for day := -1; day <= 0; day++ {
+		if start, end, ok := w.span(t, day); ok && !t.Before(start) && t.Before(end) {
+			return true
+		}
+	}
+	return false
+}
+
+// next returns when the share opens after t.
+func (w *window) next(t time.Time) time.Time {
+	for day := 0; day <= 7; day++ {
+		if start, _, ok := w.span(t, day); ok && start.After(t) {
+			return start
+		}
+	}
+	return t
+}
+
+// Handler wraps h and answers requests outside of the window with 503 and
+// when to come back.
+func (w *window) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		if w.open(now) {
+			h.ServeHTTP(rw, r)
+			return
+		}
+		next := w.next(now)
+		rw.Header().Set("Retry-After", strconv.Itoa(int(next.Sub(now).Seconds())+1))
+		http.Error(rw, "the share is closed until "+next.Format("Mon 15:04"), http.StatusServiceUnavailable)
+	})
+}
+
+// String describes the window like "09:00 until 17:00 on mon-fri".
+func (w *window) String() string {
+	var days []string
+	for d, ok := range w.days {
+		if ok {
+			days = append(days, weekdays[d])
+		}
+	}
+	s := fmt.Sprintf("%02d:%02d until %02d:%02d", w.from/60, w.from%60, w.until/60, w.until%60)
+	if len(days) < 7 {
+		s += " on " + strings.Join(days, ",")
+	}
+	return s
+}
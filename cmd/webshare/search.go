@@ -0,0 +1,135 @@
+package main
+
+import (
+	_ "embed"
+	"errors"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// maxSearchResults limits the number of matches returned by a search.
+const maxSearchResults = 500
+
+//go:embed assets/search.html
+var searchPage string
+
+var searchTemplate = template.Must(template.New("search").Funcs(listingFuncs).Parse(searchPage))
+
+var errSearchDone = errors.New("enough results")
+
+// searchResult is a single match, as rendered in html and json.
+type searchResult struct {
+	Path    string    `json:"path"`
+	URL     string    `json:"url"`
+	Dir     bool      `json:"dir"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+}
+
+// searchResponse is the result of a search.
+type searchResponse struct {
+	Query string `json:"query"`
+	Base  string `json:"base"`
+	// Root is the prefix the searched directory is shared under. Base is
+	// relative to it, the paths of results are not.
+	Root      string         `json:"-"`
+	Results   []searchResult `json:"results"`
+	Truncated bool           `json:"truncated"`
+}
+
+// search walks the tree below the request path base in dir and collects
+// entries whose name contains all of the space separated terms in q,
+// ignoring case.
+func search(dir, base, q string) (*searchResponse, error) {
+	resp := &searchResponse{Query: q, Base: base, Results: []searchResult{}}
+	terms := strings.Fields(strings.ToLower(q))
+	if len(terms) == 0 || !policy.allowed(base, true) || !policy.followed(dir, base) {
+		return resp, nil
+	}
+	root := localPath(dir, base)
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Skip unreadable parts of the tree instead of failing the search.
+			return nil
+		}
+		if p == root {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return nil
+		}
+		rp := path.Join(base, filepath.ToSlash(rel))
+		if !policy.listedEntry(dir, rp, d) {
+			return skipEntry(d)
+		}
+		name := strings.ToLower(d.Name())
+		for _, t := range terms {
+			if !strings.Contains(name, t) {
+				return nil
+			}
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if len(resp.Results) == maxSearchResults {
+			resp.Truncated = true
+			return errSearchDone
+		}
+		if d.IsDir() {
+			rp += "/"
+		}
+		u := url.URL{Path: rp}
+		resp.Results = append(resp.Results, searchResult{
+			Path:    rp,
+			URL:     u.String(),
+			Dir:     d.IsDir(),
+			Size:    fi.Size(),
+			ModTime: fi.ModTime(),
+		})
+		return nil
+	})
+	if err != nil && err != errSearchDone {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// searchHandler answers /search?q=...&path=... with html, or with json if
+// requested via format=json or the Accept header.
+func searchHandler(dir string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		base := path.Clean("/" + query.Get("path"))
+		if base != "/" {
+			base += "/"
+		}
+		resp, err := search(dir, base, query.Get("q"))
+		if err != nil {
+			logRequest(r, "search: %v", err)
+			http.Error(w, "search failed", http.StatusInternalServerError)
+			return
+		}
+		if resp.Root = mountPrefix(r); resp.Root != "" {
+			for i := range resp.Results {
+				resp.Results[i].Path = resp.Root + resp.Results[i].Path
+				resp.Results[i].URL = resp.Root + resp.Results[i].URL
+			}
+		}
+		if query.Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+			writeJSON(w, resp)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := searchTemplate.Execute(w, resp); err != nil {
+			logRequest(r, "search template: %v", err)
+		}
+	})
+}
@@ -0,0 +1,10 @@
+//go:build linux && !amd64 && !arm64
+
+package main
+
+import "errors"
+
+// installSeccomp only knows the syscall numbers of amd64 and arm64.
+func installSeccomp() error {
+	return errors.New("not supported on this architecture")
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// serviceName is the name of the service, unit or launchd job.
+const serviceName = "webshare"
+
+// launchdLabel identifies the launchd job.
+const launchdLabel = "com.github.miku.webshare"
+
+// runService runs webshare service install|uninstall|start. Install takes
+// the flags to serve with, which run in the current directory with the
+// current config file.
+func runService(args []string) {
+	usage := func() {
+		fmt.Fprintf(os.Stderr, "usage: webshare service install [flags] | uninstall | start\n\n")
+		fmt.Fprintf(os.Stderr, "install takes the flags of webshare, e.g. webshare service install -d ~/family -p 8080\n")
+		os.Exit(2)
+	}
+	if len(args) == 0 || (args[0] != "install" && len(args) > 1) {
+		usage()
+	}
+	var err error
+	switch args[0] {
+	case "install":
+		flag.CommandLine.Init("webshare service install", flag.ExitOnError)
+		flag.CommandLine.Parse(args[1:])
+		if *daemon || *tuiMode {
+			log.Fatal("-daemon and -tui do not work in a service")
+		}
+		serveArgs := args[1:]
+		if !flagSet("config") {
+			if fn, err := configFile("config.toml"); err == nil {
+				if _, err := os.Stat(fn); err == nil {
+					serveArgs = append([]string{"-config", fn}, serveArgs...)
+				}
+			}
+		}
+		var exe, dir string
+		if exe, err = os.Executable(); err != nil {
+			log.Fatal(err)
+		}
+		if dir, err = os.Getwd(); err != nil {
+			log.Fatal(err)
+		}
+		err = installService(exe, dir, serveArgs)
+	case "uninstall":
+		err = uninstallService()
+	case "start":
+		err = startService()
+	default:
+		usage()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// systemdUnit returns a unit running exe with args in dir.
+func systemdUnit(exe, dir string, args []string, wantedBy string) string {
+	cmd := []string{systemdQuote(exe)}
+	for _, arg := range args {
+		cmd = append(cmd, systemdQuote(arg))
+	}
+	return fmt.Sprintf(`[Unit]
+Description=webshare
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+ExecStart=%s
+WorkingDirectory=%s
+Restart=on-failure
+
+[Install]
+WantedBy=%s
+`, strings.Join(cmd, " "), strings.ReplaceAll(dir, "%", "%%"), wantedBy)
+}
+
+// systemdQuote quotes s for the command line of a unit, where % and $
+// start specifiers and variables.
+func systemdQuote(s string) string {
+	s = strings.NewReplacer("%", "%%", "$", "$$").Replace(s)
+	if s != "" && !strings.ContainsAny(s, " \t\n\"'\\;") {
+		return s
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+// launchdPlist returns a job running exe with args in dir, logging to
+// logFile.
+func launchdPlist(exe, dir string, args []string, logFile string) string {
+	esc := func(s string) string {
+		var b strings.Builder
+		xml.EscapeText(&b, []byte(s))
+		return b.String()
+	}
+	var prog strings.Builder
+	for _, arg := range append([]string{exe}, args...) {
+		fmt.Fprintf(&prog, "\t\t<string>%s</string>\n", esc(arg))
+	}
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>%s</string>
+	<key>ProgramArguments</key>
+	<array>
+%s	</array>
+	<key>WorkingDirectory</key>
+	<string>%s</string>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<dict>
+		<key>SuccessfulExit</key>
+		<false/>
+	</dict>
+	<key>StandardErrorPath</key>
+	<string>%s</string>
+</dict>
+</plist>
+`, launchdLabel, prog.String(), esc(dir), esc(logFile))
+}
+
+// writeServiceFile writes a unit or plist, creating its directory.
+func writeServiceFile(fn, content string) error {
+	if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(fn, []byte(content), 0644)
+}
@@ -0,0 +1,128 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+)
+
+// serviceFile returns where the unit or plist goes: for the whole system
+// when run as root, otherwise for the user.
+func serviceFile() (string, error) {
+	root := os.Geteuid() == 0
+	switch runtime.GOOS {
+	case "linux":
+		if root {
+			return "/etc/systemd/system/" + serviceName + ".service", nil
+		}
+		dir, err := os.UserConfigDir()
+		return filepath.Join(dir, "systemd", "user", serviceName+".service"), err
+	case "darwin":
+		if root {
+			return "/Library/LaunchDaemons/" + launchdLabel + ".plist", nil
+		}
+		home, err := os.UserHomeDir()
+		return filepath.Join(home, "Library", "LaunchAgents", launchdLabel+".plist"), err
+	}
+	return "", fmt.Errorf("webshare service only supports systemd, launchd and windows, not %s", runtime.GOOS)
+}
+
+// systemctl runs systemctl for the system or user units.
+func systemctl(args ...string) error {
+	if os.Geteuid() != 0 {
+		args = append([]string{"--user"}, args...)
+	}
+	cmd := exec.Command("systemctl", args...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	return cmd.Run()
+}
+
+func launchctl(args ...string) error {
+	cmd := exec.Command("launchctl", args...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	return cmd.Run()
+}
+
+// installService registers exe with args to run in dir on boot or login.
+func installService(exe, dir string, args []string) error {
+	fn, err := serviceFile()
+	if err != nil {
+		return err
+	}
+	if runtime.GOOS == "darwin" {
+		logFile := "/Library/Logs/webshare.log"
+		if os.Geteuid() != 0 {
+			home, _ := os.UserHomeDir()
+			logFile = filepath.Join(home, "Library", "Logs", "webshare.log")
+		}
+		if err := writeServiceFile(fn, launchdPlist(exe, dir, args, logFile)); err != nil {
+			return err
+		}
+		fmt.Printf("installed %s, logging to %s\n", fn, logFile)
+	} else {
+		wantedBy := "default.target"
+		if os.Geteuid() == 0 {
+			wantedBy = "multi-user.target"
+		}
+		if err := writeServiceFile(fn, systemdUnit(exe, dir, args, wantedBy)); err != nil {
+			return err
+		}
+		if err := systemctl("daemon-reload"); err != nil {
+			return err
+		}
+		if err := systemctl("enable", serviceName); err != nil {
+			return err
+		}
+		fmt.Printf("installed %s, logging to the journal\n", fn)
+		if os.Geteuid() != 0 {
+			fmt.Println("user units stop when you log out, unless you run loginctl enable-linger")
+		}
+	}
+	fmt.Println("start it with webshare service start")
+	return nil
+}
+
+// uninstallService stops the service and removes it.
+func uninstallService() error {
+	fn, err := serviceFile()
+	if err != nil {
+		return err
+	}
+	if runtime.GOOS == "darwin" {
+		launchctl("unload", "-w", fn)
+	} else {
+		systemctl("disable", "--now", serviceName)
+	}
+	if err := os.Remove(fn); err != nil {
+		return err
+	}
+	if runtime.GOOS == "linux" {
+		systemctl("daemon-reload")
+	}
+	fmt.Printf("removed %s\n", fn)
+	return nil
+}
+
+// startService starts the installed service.
+func startService() error {
+	fn, err := serviceFile()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(fn); err != nil {
+		return fmt.Errorf("not installed, see webshare service install: %w", err)
+	}
+	if runtime.GOOS == "darwin" {
+		// Loading runs the job, which stays loaded across logins.
+		return launchctl("load", "-w", fn)
+	}
+	return systemctl("start", serviceName)
+}
+
+// notifyService does nothing, systemd and launchd stop webshare with
+// SIGTERM.
+func notifyService(c chan<- os.Signal) {}
@@ -0,0 +1,106 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// installService registers exe with args as a service started on boot.
+// Services have no working directory of their own, so dir is passed as
+// -d unless the args share something already.
+func installService(exe, dir string, args []string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("connecting to the service manager, which needs an administrator: %w", err)
+	}
+	defer m.Disconnect()
+	if !flagSet("d") && !flagSet("stdin") && !flagSet("archive") && !flagSet("proxy") && !flagSet("routes") {
+		args = append([]string{"-d", dir}, args...)
+	}
+	s, err := m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: "webshare",
+		Description: "Shares files on the local network.",
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	fmt.Printf("installed the %s service, start it with webshare service start\n", serviceName)
+	return nil
+}
+
+// uninstallService stops the service and removes it.
+func uninstallService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("connecting to the service manager, which needs an administrator: %w", err)
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("not installed: %w", err)
+	}
+	defer s.Close()
+	s.Control(svc.Stop)
+	if err := s.Delete(); err != nil {
+		return err
+	}
+	fmt.Printf("removed the %s service\n", serviceName)
+	return nil
+}
+
+// startService starts the installed service.
+func startService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("connecting to the service manager, which needs an administrator: %w", err)
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("not installed, see webshare service install: %w", err)
+	}
+	defer s.Close()
+	return s.Start()
+}
+
+// notifyService reports to the service manager if webshare runs as a
+// service, and passes its stop requests on to c like an interrupt.
+func notifyService(c chan<- os.Signal) {
+	if ok, err := svc.IsWindowsService(); err != nil || !ok {
+		return
+	}
+	go func() {
+		if err := svc.Run(serviceName, serviceHandler{stop: c}); err != nil {
+			log.Printf("service: %v", err)
+		}
+	}()
+}
+
+type serviceHandler struct {
+	stop chan<- os.Signal
+}
+
+func (h serviceHandler) Execute(args []string, r <-chan svc.ChangeRequest, s chan<- svc.Status) (bool, uint32) {
+	s <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for c := range r {
+		switch c.Cmd {
+		case svc.Interrogate:
+			s <- c.CurrentStatus
+		case svc.Stop, svc.Shutdown:
+			s <- svc.Status{State: svc.StopPending, WaitHint: uint32(*drainWait / time.Millisecond)}
+			h.stop <- os.Interrupt
+			// The process ends once the transfers are done.
+			select {}
+		}
+	}
+	return false, 0
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// configFile returns the location of a file in the webshare configuration
+// directory.
+func configFile(name string) (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "webshare", name), nil
+}
+
+// loadHostKey reads the sftp host key, generating and saving a new one on
+// first run, so clients do not see a changed key on every start.
+func loadHostKey() (ssh.Signer, error) {
+	fn, err := configFile("ssh_host_ed25519_key")
+	if err != nil {
+		return nil, err
+	}
+	if b, err := os.ReadFile(fn); err == nil {
+		return ssh.ParsePrivateKey(b)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	block, err := ssh.MarshalPrivateKey(key, "webshare")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(fn), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(fn, pem.EncodeToMemory(block), 0600); err != nil {
+		return nil, err
+	}
+	log.Printf("generated sftp host key at %s", fn)
+	return ssh.NewSignerFromKey(key)
+}
+
+// serveSFTP runs an sftp server for dir on ln with the given host key
+// until ctx is done.
+func serveSFTP(ctx context.Context, ln net.Listener, dir string, signer ssh.Signer, writable bool) error {
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+	log.Printf("sftp listening on %s [%s]", ln.Addr(), ssh.FingerprintSHA256(signer.PublicKey()))
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if !clientAllowed(conn.RemoteAddr()) {
+			log.Printf("sftp %s: denied access", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+		go handleSSHConn(conn, config, dir, writable)
+	}
+}
+
+func handleSSHConn(conn net.Conn, config *ssh.ServerConfig, dir string, writable bool) {
+	defer conn.Close()
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		log.Printf("sftp %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+	defer sconn.Close()
+	log.Println(conn.RemoteAddr(), "sftp connect")
+	go ssh.DiscardRequests(reqs)
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			nc.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		ch, requests, err := nc.Accept()
+		if err != nil {
+			log.Printf("sftp %s: %v", conn.RemoteAddr(), err)
+			return
+		}
+		go func() {
+			for req := range requests {
+				// The payload is the length prefixed subsystem name.
+				ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+			}
+		}()
+		fs := &sftpFS{dir: dir, writable: writable, remote: conn.RemoteAddr().String()}
+		server := sftp.NewRequestServer(ch, sftp.Handlers{
+			FileGet:  fs,
+			FilePut:  fs,
+			FileCmd:  fs,
+			FileList: fs,
+		})
+		if err := server.Serve(); err != nil && err != io.EOF {
+			log.Printf("sftp %s: %v", conn.RemoteAddr(), err)
+		}
+		server.Close()
+	}
+}
+
+// sftpFS implements the sftp request handlers on top of a directory, with
+// the same confinement rules as the http uploads.
+type sftpFS struct {
+	dir      string
+	writable bool
+	remote   string
+}
+
+func (s *sftpFS) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	log.Println(s.remote, "sftp get", r.Filepath)
+	if _, err := policy.stat(s.dir, r.Filepath); err != nil {
+		return nil, err
+	}
+	return os.Open(localPath(s.dir, r.Filepath))
+}
+
+func (s *sftpFS) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	if !s.writable {
+		return nil, sftp.ErrSSHFxPermissionDenied
+	}
+	fn, err := resolvePath(s.dir, r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	log.Println(s.remote, "sftp put", r.Filepath)
+	flags := os.O_WRONLY | os.O_CREATE
+	pflags := r.Pflags()
+	if pflags.Trunc {
+		flags |= os.O_TRUNC
+	}
+	if pflags.Excl {
+		flags |= os.O_EXCL
+	}
+	return os.OpenFile(fn, flags, 0644)
+}
+
+func (s *sftpFS) Filecmd(r *sftp.Request) error {
+	if !s.writable {
+		return sftp.ErrSSHFxPermissionDenied
+	}
+	fn, err := resolvePath(s.dir, r.Filepath)
+	if err != nil {
+		return err
+	}
+	log.Println(s.remote, "sftp", r.Method, r.Filepath)
+	switch r.Method {
+	case "Setstat":
+		return nil
+	case "Rename":
+		target, err := resolvePath(s.dir, r.Target)
+		if err != nil {
+			return err
+		}
+		return os.Rename(fn, target)
+	case "Rmdir", "Remove":
+		return os.Remove(fn)
+	case "Mkdir":
+		return os.Mkdir(fn, 0755)
+	default:
+		// Links could point outside of the shared directory.
+		return sftp.ErrSSHFxOpUnsupported
+	}
+}
+
+func (s *sftpFS) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	fi, err := policy.stat(s.dir, r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	switch r.Method {
+	case "List":
+		entries, err := os.ReadDir(localPath(s.dir, r.Filepath))
+		if err != nil {
+			return nil, err
+		}
+		var infos listerAt
+		for _, e := range entries {
+			if !policy.listedEntry(s.dir, path.Join(r.Filepath, e.Name()), e) {
+				continue
+			}
+			fi, err := e.Info()
+			if err != nil {
+				continue
+			}
+			infos = append(infos, fi)
+		}
+		return infos, nil
+	case "Stat":
+		return listerAt{fi}, nil
+	default:
+		return nil, sftp.ErrSSHFxOpUnsupported
+	}
+}
+
+type listerAt []os.FileInfo
+
+func (l listerAt) ListAt(ls []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(ls, l[offset:])
+	if n < len(ls) {
+		return n, io.EOF
+	}
+	return n, nil
+}
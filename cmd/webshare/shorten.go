@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// shortener replaces links by shorter ones for the qr codes, which then
+// have fewer modules and scan more reliably.
+type shortener interface {
+	shorten(link string) (string, error)
+}
+
+// newShortener returns the shortener for -shorten, words for a built-in
+// alias or the url of a shortener service. Links to the share go to
+// target.
+func newShortener(spec, target string) (shortener, error) {
+	if spec == "words" {
+		return &aliasShortener{alias: "/_/" + wordAlias(), target: target}, nil
+	}
+	u, err := url.Parse(spec)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("want words or an http(s) url, got %q", spec)
+	}
+	return &remoteShortener{endpoint: spec}, nil
+}
+
+var (
+	aliasAdjectives = strings.Fields("able bold brave calm clever cosy eager fair fancy gentle glad grand happy jolly keen kind lively lucky merry mighty neat noble proud quick quiet rapid shiny smart sunny swift tidy witty")
+	aliasNouns      = strings.Fields("badger bear beaver bison camel crane dolphin eagle falcon ferret fox gecko heron koala lemur lion lynx moose otter owl panda parrot pelican puffin rabbit raven robin seal swan tiger walrus zebra")
+)
+
+// wordAlias returns a random alias like brave-otter-42, easy to read out
+// and type.
+func wordAlias() string {
+	return fmt.Sprintf("%s-%s-%d", aliasAdjectives[rand.IntN(len(aliasAdjectives))], aliasNouns[rand.IntN(len(aliasNouns))], 2+rand.IntN(98))
+}
+
+// aliasShortener serves a memorable alias on the share itself, which
+// redirects to the link.
+type aliasShortener struct {
+	// alias is the path of the short link.
+	alias string
+	// target is where the alias redirects, the path of the link with the
+	// token and fragment if any.
+	target string
+}
+
+func (a *aliasShortener) shorten(link string) (string, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", err
+	}
+	return u.Scheme + "://" + u.Host + a.alias, nil
+}
+
+// Handler wraps h and redirects the alias, which is only used for shares
+// without a secret in the link.
+func (a *aliasShortener) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != a.alias {
+			h.ServeHTTP(w, r)
+			return
+		}
+		http.Redirect(w, r, a.target, http.StatusFound)
+	})
+}
+
+// remoteShortener posts the link as the form field url to a shortener
+// service, which answers with the short link as text.
+type remoteShortener struct {
+	endpoint string
+}
+
+func (s *remoteShortener) shorten(link string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	body := strings.NewReader(url.Values{"url": {link}}.Encode())
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("%s: %s", s.endpoint, resp.Status)
+	}
+	short := strings.TrimSpace(string(b))
+	if u, err := url.Parse(short); err != nil || u.Host == "" {
+		return "", fmt.Errorf("%s: no link in the response", s.endpoint)
+	}
+	return short, nil
+}
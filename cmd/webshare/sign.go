@@ -0,0 +1,210 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// loadSignKey reads the key for signed links from fn, or from the
+// configuration directory if fn is empty. A missing key is generated, so
+// links stay valid across restarts.
+func loadSignKey(fn string) ([]byte, error) {
+	if fn == "" {
+		var err error
+		if fn, err = configFile("sign_key"); err != nil {
+			return nil, err
+		}
+	}
+	if b, err := os.ReadFile(fn); err == nil {
+		return hex.DecodeString(strings.TrimSpace(string(b)))
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(fn), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(fn, []byte(hex.EncodeToString(key)+"\n"), 0600); err != nil {
+		return nil, err
+	}
+	log.Printf("generated signing key at %s", fn)
+	return key, nil
+}
+
+// linkSigner creates and checks links to a single path that expire.
+type linkSigner struct {
+	mu  sync.RWMutex
+	key []byte
+	// apiToken is the bearer token /api/sign takes, the one of -admin. The
+	// api is off without one.
+	apiToken string
+}
+
+// setKey replaces the key, which invalidates the links signed so far if it
+// changed.
+func (s *linkSigner) setKey(key []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.key = key
+}
+
+func (s *linkSigner) mac(p string, exp int64) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	h := hmac.New(sha256.New, s.key)
+	fmt.Fprintf(h, "%s\n%d", p, exp)
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// sign returns the signed link to the URL path p, valid until exp.
+func (s *linkSigner) sign(p string, exp time.Time) string {
+	p = path.Clean("/" + p)
+	q := url.Values{}
+	q.Set("exp", strconv.FormatInt(exp.Unix(), 10))
+	q.Set("sig", s.mac(p, exp.Unix()))
+	u := url.URL{Path: p, RawQuery: q.Encode()}
+	return u.String()
+}
+
+var (
+	errBadSignature = errors.New("invalid signature")
+	errLinkExpired  = errors.New("link expired")
+)
+
+// verify checks the signature of a request, if it has one.
+func (s *linkSigner) verify(r *http.Request) (signed bool, err error) {
+	query := r.URL.Query()
+	sig := query.Get("sig")
+	if sig == "" {
+		return false, nil
+	}
+	exp, err := strconv.ParseInt(query.Get("exp"), 10, 64)
+	if err != nil || !hmac.Equal([]byte(sig), []byte(s.mac(path.Clean(r.URL.Path), exp))) {
+		return true, errBadSignature
+	}
+	if time.Now().Unix() > exp {
+		return true, errLinkExpired
+	}
+	return true, nil
+}
+
+// Handler serves requests with a valid signature with direct, which skips
+// the token prefix, and passes the others to h, unless required is set. It
+// also answers /api/sign?path=...&ttl=1h for requests with the api token.
+// The peer address proves nothing, since tunnels and reverse proxies
+// connect from this machine too.
+func (s *linkSigner) Handler(required bool, direct, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/sign" && s.apiToken != "" {
+			if !bearerOK(r, s.apiToken) {
+				logRequest(r, "%s sign token rejected", r.RemoteAddr)
+				emitAuthFailure(r, "sign token rejected")
+				w.Header().Set("WWW-Authenticate", `Bearer realm="webshare"`)
+				http.Error(w, "invalid admin token", http.StatusUnauthorized)
+				return
+			}
+			s.serveSign(w, r)
+			return
+		}
+		signed, err := s.verify(r)
+		if err != nil {
+			emitAuthFailure(r, err.Error())
+		}
+		switch {
+		case errors.Is(err, errLinkExpired):
+			http.Error(w, err.Error(), http.StatusGone)
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case signed && r.Method != http.MethodGet && r.Method != http.MethodHead:
+			http.Error(w, "signed links are read-only", http.StatusMethodNotAllowed)
+		case signed:
+			direct.ServeHTTP(w, r)
+		case required:
+			http.Error(w, "a signed link is required", http.StatusForbidden)
+		default:
+			h.ServeHTTP(w, r)
+		}
+	})
+}
+
+func (s *linkSigner) serveSign(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	ttl := time.Hour
+	if v := query.Get("ttl"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			http.Error(w, "invalid ttl", http.StatusBadRequest)
+			return
+		}
+		ttl = d
+	}
+	exp := time.Now().Add(ttl)
+	writeJSON(w, struct {
+		URL     string    `json:"url"`
+		Expires time.Time `json:"expires"`
+	}{s.sign(query.Get("path"), exp), exp.Truncate(time.Second)})
+}
+
+// runSign implements "webshare sign [flags] path", which prints signed links
+// to path for a server using the same key.
+func runSign(args []string) {
+	fs := flag.NewFlagSet("sign", flag.ExitOnError)
+	ttl := fs.Duration("ttl", time.Hour, "how long the link stays valid")
+	keyFile := fs.String("sign-key", "", "file with the signing key, by default in the user config directory")
+	base := fs.String("base", "", "server address to prefix the link with, e.g. http://192.168.1.10:3000")
+	port := fs.Int("p", 3000, "port of the server, used if -base is not set")
+	useTLS := fs.Bool("tls", false, "print https links, for a server with -tls, -cert or -acme, used if -base is not set")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: webshare sign [flags] path\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	key, err := loadSignKey(*keyFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	s := &linkSigner{key: key}
+	link := s.sign(fs.Arg(0), time.Now().Add(*ttl))
+	if *base != "" {
+		fmt.Println(strings.TrimSuffix(*base, "/") + link)
+		return
+	}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		log.Fatal(err)
+	}
+	scheme := "http"
+	if *useTLS {
+		scheme = "https"
+	}
+	for _, e := range endpoints("", *port, ifaces) {
+		if !e.ip.IsLoopback() {
+			u := &url.URL{Scheme: scheme, Host: net.JoinHostPort(e.host, strconv.Itoa(e.port))}
+			fmt.Println(u.String() + link)
+		}
+	}
+}
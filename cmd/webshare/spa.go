@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// spaHandler wraps h and answers requests for paths that do not exist with
+// the index.html of dir, so client side routing of single-page apps works.
+func spaHandler(dir string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if _, err := os.Stat(localPath(dir, r.URL.Path)); !os.IsNotExist(err) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		f, err := os.Open(filepath.Join(dir, "index.html"))
+		if err != nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		http.ServeContent(w, r, "index.html", fi.ModTime(), f)
+	})
+}
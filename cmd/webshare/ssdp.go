@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+// ssdpPath serves the UPnP device description of -ssdp.
+const ssdpPath = "/_/upnp.xml"
+
+const (
+	ssdpDeviceType = "urn:schemas-upnp-org:device:Basic:1"
+	ssdpMaxAge     = 1800
+)
+
+var ssdpGroup = &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900}
+
+// ssdpDevice announces the share as a UPnP basic device, which Windows
+// network discovery and smart TVs list, opening presentation in a browser.
+type ssdpDevice struct {
+	uuid         string
+	name         string
+	scheme       string
+	port         int
+	presentation string
+
+	conn  *ipv4.PacketConn
+	links []ssdpLink
+}
+
+// ssdpLink is an interface the device is announced on, with the address
+// the description is reachable at from there.
+type ssdpLink struct {
+	ifi net.Interface
+	ip  *net.IPNet
+}
+
+// newSSDP returns a device for the share on port, presenting the path. The
+// device id is derived from the host name and port, so it stays the same
+// across restarts and does not show up as a new device every time.
+func newSSDP(scheme string, port int, presentation string) *ssdpDevice {
+	hostname, _ := os.Hostname()
+	sum := sha256.Sum256([]byte(hostname + ":" + strconv.Itoa(port)))
+	sum[6] = sum[6]&0x0f | 0x50
+	sum[8] = sum[8]&0x3f | 0x80
+	short, _, _ := strings.Cut(hostname, ".")
+	return &ssdpDevice{
+		uuid:         fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16]),
+		name:         "webshare on " + short,
+		scheme:       scheme,
+		port:         port,
+		presentation: presentation,
+	}
+}
+
+// listen opens the multicast socket on the interfaces with one of ips.
+func (d *ssdpDevice) listen(ips []net.IP) error {
+	conn, ifaces, err := listenMulticast(ssdpGroup)
+	if err != nil {
+		return err
+	}
+	for _, ifi := range ifaces {
+		addrs, _ := ifi.Addrs()
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			for _, ip := range ips {
+				if ipnet.IP.Equal(ip) {
+					d.links = append(d.links, ssdpLink{ifi: ifi, ip: ipnet})
+				}
+			}
+		}
+	}
+	if len(d.links) == 0 {
+		conn.Close()
+		return fmt.Errorf("ssdp: no multicast interface with a local network address")
+	}
+	d.conn = conn
+	return nil
+}
+
+// Handler wraps h and serves the device description, which does not need
+// the token or any other credentials.
+func (d *ssdpDevice) Handler(h http.Handler) http.Handler {
+	type device struct {
+		DeviceType      string `xml:"deviceType"`
+		FriendlyName    string `xml:"friendlyName"`
+		Manufacturer    string `xml:"manufacturer"`
+		ModelName       string `xml:"modelName"`
+		UDN             string `xml:"UDN"`
+		PresentationURL string `xml:"presentationURL"`
+	}
+	type root struct {
+		XMLName     xml.Name `xml:"urn:schemas-upnp-org:device-1-0 root"`
+		SpecVersion struct {
+			Major int `xml:"major"`
+			Minor int `xml:"minor"`
+		} `xml:"specVersion"`
+		Device device `xml:"device"`
+	}
+	desc := root{Device: device{
+		DeviceType:      ssdpDeviceType,
+		FriendlyName:    d.name,
+		Manufacturer:    "webshare",
+		ModelName:       "webshare",
+		UDN:             "uuid:" + d.uuid,
+		PresentationURL: d.presentation,
+	}}
+	desc.SpecVersion.Major = 1
+	b, err := xml.MarshalIndent(desc, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	b = append([]byte(xml.Header), b...)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != ssdpPath {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+		w.Write(b)
+	})
+}
+
+// targets returns the notification types of the device with their unique
+// service names.
+func (d *ssdpDevice) targets() [][2]string {
+	usn := "uuid:" + d.uuid
+	return [][2]string{
+		{"upnp:rootdevice", usn + "::upnp:rootdevice"},
+		{usn, usn},
+		{ssdpDeviceType, usn + "::" + ssdpDeviceType},
+	}
+}
+
+// location returns the URL of the description at ip.
+func (d *ssdpDevice) location(ip net.IP) string {
+	return fmt.Sprintf("%s://%s%s", d.scheme, net.JoinHostPort(ip.String(), strconv.Itoa(d.port)), ssdpPath)
+}
+
+// notify sends a NOTIFY message with nts, alive or byebye, for every target
+// on every interface.
+func (d *ssdpDevice) notify(nts string) {
+	for _, l := range d.links {
+		d.conn.SetMulticastInterface(&l.ifi)
+		for _, t := range d.targets() {
+			var b bytes.Buffer
+			fmt.Fprintf(&b, "NOTIFY * HTTP/1.1\r\nHOST: %s\r\nNT: %s\r\nNTS: %s\r\nUSN: %s\r\n", ssdpGroup, t[0], nts, t[1])
+			if nts == "ssdp:alive" {
+				fmt.Fprintf(&b, "CACHE-CONTROL: max-age=%d\r\nLOCATION: %s\r\nSERVER: webshare UPnP/1.1 webshare/1.0\r\n", ssdpMaxAge, d.location(l.ip.IP))
+			}
+			b.WriteString("\r\n")
+			d.conn.WriteTo(b.Bytes(), &ipv4.ControlMessage{IfIndex: l.ifi.Index}, ssdpGroup)
+		}
+	}
+}
+
+// link returns the interface a request from addr came in on, by index or
+// otherwise by network.
+func (d *ssdpDevice) link(ifIndex int, addr *net.UDPAddr) ssdpLink {
+	for _, l := range d.links {
+		if l.ifi.Index == ifIndex && l.ip.Contains(addr.IP) {
+			return l
+		}
+	}
+	for _, l := range d.links {
+		if l.ip.Contains(addr.IP) {
+			return l
+		}
+	}
+	return d.links[0]
+}
+
+// serve answers searches until ctx is done, announcing the device until
+// then and saying goodbye at the end.
+func (d *ssdpDevice) serve(ctx context.Context) {
+	go func() {
+		// Announcements are repeated well before they expire.
+		t := time.NewTicker(ssdpMaxAge / 3 * time.Second)
+		defer t.Stop()
+		d.notify("ssdp:alive")
+		for {
+			select {
+			case <-t.C:
+				d.notify("ssdp:alive")
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		d.notify("ssdp:byebye")
+		d.conn.Close()
+	}()
+	buf := make([]byte, 2048)
+	for {
+		n, cm, from, err := d.conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("ssdp: %v", err)
+			}
+			return
+		}
+		addr, ok := from.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		r, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(buf[:n])))
+		if err != nil || r.Method != "M-SEARCH" || r.Header.Get("Man") != `"ssdp:discover"` {
+			continue
+		}
+		st := r.Header.Get("St")
+		ifIndex := 0
+		if cm != nil {
+			ifIndex = cm.IfIndex
+		}
+		l := d.link(ifIndex, addr)
+		for _, t := range d.targets() {
+			if st != "ssdp:all" && st != t[0] {
+				continue
+			}
+			msg := fmt.Sprintf("HTTP/1.1 200 OK\r\nCACHE-CONTROL: max-age=%d\r\nEXT:\r\nLOCATION: %s\r\nSERVER: webshare UPnP/1.1 webshare/1.0\r\nST: %s\r\nUSN: %s\r\n\r\n",
+				ssdpMaxAge, d.location(l.ip.IP), t[0], t[1])
+			// Responses are spread over a second, the shortest time
+			// searchers wait for them.
+			time.AfterFunc(rand.N(time.Second), func() {
+				d.conn.WriteTo([]byte(msg), nil, addr)
+			})
+		}
+	}
+}
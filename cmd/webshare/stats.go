@@ -0,0 +1,171 @@
+package main
+
+import (
+	"cmp"
+	_ "embed"
+	"fmt"
+	"html/template"
+	"maps"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statsPath serves the download statistics, as a page or as json with
+// format=json or the Accept header.
+const statsPath = "/_/stats"
+
+//go:embed assets/stats.html
+var statsPage string
+
+var statsTemplate = template.Must(template.New("stats").Funcs(listingFuncs).Parse(statsPage))
+
+// fileStats are the downloads of one path. Bytes include range requests
+// and aborted transfers, Downloads only complete ones.
+type fileStats struct {
+	Path      string    `json:"path"`
+	Downloads int       `json:"downloads"`
+	Bytes     int64     `json:"bytes"`
+	Last      time.Time `json:"last"`
+	// Locations counts the complete downloads per location of public
+	// clients with -geoip.
+	Locations map[string]int `json:"locations,omitempty"`
+}
+
+// downloadStats counts the downloads and bytes sent per path.
+type downloadStats struct {
+	started time.Time
+
+	mu    sync.Mutex
+	files map[string]*fileStats
+}
+
+func newDownloadStats() *downloadStats {
+	return &downloadStats{started: time.Now(), files: make(map[string]*fileStats)}
+}
+
+func (s *downloadStats) count(p string, complete bool, n int64, loc string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f := s.files[p]
+	if f == nil {
+		f = &fileStats{Path: p}
+		s.files[p] = f
+	}
+	if complete {
+		f.Downloads++
+		if loc != "" {
+			if f.Locations == nil {
+				f.Locations = make(map[string]int)
+			}
+			f.Locations[loc]++
+		}
+	}
+	f.Bytes += n
+	f.Last = time.Now()
+}
+
+// list returns the stats of all paths, the most downloaded first.
+func (s *downloadStats) list() []fileStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var files []fileStats
+	for _, f := range s.files {
+		c := *f
+		c.Locations = maps.Clone(f.Locations)
+		files = append(files, c)
+	}
+	slices.SortFunc(files, func(a, b fileStats) int {
+		return cmp.Or(cmp.Compare(b.Downloads, a.Downloads), cmp.Compare(b.Bytes, a.Bytes), strings.Compare(a.Path, b.Path))
+	})
+	return files
+}
+
+// totals returns the number of downloads and bytes sent of all paths.
+func (s *downloadStats) totals() (downloads int, bytes int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range s.files {
+		downloads += f.Downloads
+		bytes += f.Bytes
+	}
+	return downloads, bytes
+}
+
+// Handler wraps h, counts its file transfers and serves the statistics.
+func (s *downloadStats) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == statsPath {
+			s.serve(w, r)
+			return
+		}
+		rec := &responseRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r)
+		if rec.isDownload(r) {
+			p := path.Clean("/" + r.URL.Path)
+			s.count(p, true, rec.written, clientLocation(r))
+			emit(event{
+				Event:  "downloaded",
+				Remote: remoteHost(r),
+				Path:   p,
+				Bytes:  rec.written,
+				Text:   fmt.Sprintf("%s downloaded %s (%s)", remoteHost(r), p, formatSize(rec.written)),
+			})
+		} else if r.Method == http.MethodGet && rec.Status() == http.StatusPartialContent && !auxiliary(r) {
+			s.count(path.Clean("/"+r.URL.Path), false, rec.written, "")
+		}
+	})
+}
+
+func (s *downloadStats) serve(w http.ResponseWriter, r *http.Request) {
+	files := s.list()
+	var total fileStats
+	total.Downloads, total.Bytes = s.totals()
+	w.Header().Set("Cache-Control", "no-store")
+	if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+		writeJSON(w, map[string]any{
+			"since":     s.started,
+			"downloads": total.Downloads,
+			"bytes":     total.Bytes,
+			"files":     files,
+		})
+		return
+	}
+	type row struct {
+		fileStats
+		// URL is relative to the stats page, so it keeps the token.
+		URL  string
+		From string
+	}
+	rows := make([]row, len(files))
+	for i, f := range files {
+		rows[i] = row{f, (&url.URL{Path: "../" + strings.TrimPrefix(f.Path, "/")}).String(), formatLocations(f.Locations)}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := statsTemplate.Execute(w, map[string]any{
+		"Since": s.started,
+		"Total": total,
+		"Files": rows,
+		"GeoIP": geo != nil,
+	})
+	if err != nil {
+		logRequest(r, "stats template: %v", err)
+	}
+}
+
+// formatLocations lists the locations with their counts, the most frequent
+// first, like "Berlin, DE (2), US (1)".
+func formatLocations(locs map[string]int) string {
+	keys := slices.SortedFunc(maps.Keys(locs), func(a, b string) int {
+		return cmp.Or(cmp.Compare(locs[b], locs[a]), strings.Compare(a, b))
+	})
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%s (%d)", k, locs[k])
+	}
+	return strings.Join(parts, ", ")
+}
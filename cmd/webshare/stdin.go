@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// stdinFile holds data read from a pipe, either in memory or spooled to a
+// temporary file, and serves it once the pipe is drained.
+type stdinFile struct {
+	name    string
+	done    chan struct{}
+	content io.ReaderAt
+	size    int64
+	modTime time.Time
+	err     error
+	tmp     *os.File
+	// path is the file holding the data, if any, which each request opens
+	// for itself to be sent via sendfile.
+	path string
+}
+
+// readStdin starts buffering r in the background. At most max bytes are
+// accepted, zero means no limit.
+func readStdin(r io.Reader, name string, max int64, inMemory bool) *stdinFile {
+	sf := &stdinFile{name: name, done: make(chan struct{})}
+	go func() {
+		defer close(sf.done)
+		if max > 0 {
+			// Read one byte more to be able to tell that the limit was hit.
+			r = io.LimitReader(r, max+1)
+		}
+		var (
+			n   int64
+			err error
+		)
+		if inMemory {
+			var buf bytes.Buffer
+			n, err = io.Copy(&buf, r)
+			sf.content = bytes.NewReader(buf.Bytes())
+		} else {
+			if sf.tmp, err = os.CreateTemp("", "webshare-stdin-*"); err != nil {
+				sf.err = err
+				return
+			}
+			n, err = io.Copy(sf.tmp, r)
+			sf.content, sf.path = sf.tmp, sf.tmp.Name()
+		}
+		switch {
+		case err != nil:
+			sf.err = err
+		case max > 0 && n > max:
+			sf.err = fmt.Errorf("stdin exceeds limit of %s", formatSize(max))
+		}
+		if sf.err != nil {
+			log.Printf("stdin: %v", sf.err)
+			return
+		}
+		sf.size, sf.modTime = n, time.Now()
+		log.Printf("stdin buffered as %s [%d]", sf.name, n)
+	}()
+	return sf
+}
+
+// openFile serves the file fn the way data from stdin is served, for
+// webshare send.
+func openFile(fn string) (*stdinFile, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if fi.IsDir() {
+		f.Close()
+		return nil, fmt.Errorf("%s is a directory, share it with webshare serve", fn)
+	}
+	sf := &stdinFile{name: fi.Name(), done: make(chan struct{}), content: f, size: fi.Size(), modTime: fi.ModTime(), path: fn}
+	close(sf.done)
+	return sf, nil
+}
+
+// Close removes the spool file, if any.
+func (sf *stdinFile) Close() error {
+	if sf.tmp == nil {
+		return nil
+	}
+	sf.tmp.Close()
+	return os.Remove(sf.tmp.Name())
+}
+
+// ServeHTTP waits until stdin is fully buffered, then serves it. The root
+// path redirects to the file, every other path is not found.
+func (sf *stdinFile) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/":
+		u := url.URL{Path: "/" + sf.name}
+		http.Redirect(w, r, u.String(), http.StatusFound)
+		return
+	case "/" + sf.name:
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	select {
+	case <-sf.done:
+	case <-r.Context().Done():
+		return
+	}
+	if sf.err != nil {
+		http.Error(w, "stdin not available", http.StatusServiceUnavailable)
+		return
+	}
+	// Each request gets its own reader, since requests run concurrently.
+	// A file of its own keeps the sendfile path, unless the sandbox denies
+	// opening it or it changed since.
+	var content io.ReadSeeker = io.NewSectionReader(sf.content, 0, sf.size)
+	if sf.path != "" {
+		if f, err := os.Open(sf.path); err == nil {
+			defer f.Close()
+			if fi, err := f.Stat(); err == nil && fi.Size() == sf.size {
+				content = f
+			}
+		}
+	}
+	http.ServeContent(w, r, sf.name, sf.modTime, content)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// topDownloads is the number of files listed in the summary.
+const topDownloads = 10
+
+// requestCounter counts the clients, requests, bytes and errors of all
+// requests, also those refused before they reach the files, for the
+// summary at the end of the share.
+type requestCounter struct {
+	started time.Time
+
+	mu       sync.Mutex
+	clients  map[string]bool
+	requests int
+	sent     int64
+	errors   map[int]int
+}
+
+func newRequestCounter() *requestCounter {
+	return &requestCounter{started: time.Now(), clients: make(map[string]bool), errors: make(map[int]int)}
+}
+
+// Handler wraps h and counts its requests.
+func (c *requestCounter) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &responseRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r)
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.clients[remoteHost(r)] = true
+		c.requests++
+		c.sent += rec.written
+		if rec.Status() >= 400 {
+			c.errors[rec.Status()]++
+		}
+	})
+}
+
+// shareSummary describes the share once it ended.
+type shareSummary struct {
+	Started   time.Time `json:"started"`
+	Uptime    float64   `json:"uptime"`
+	Clients   int       `json:"clients"`
+	Requests  int       `json:"requests"`
+	Files     int       `json:"files"`
+	Downloads int       `json:"downloads"`
+	Bytes     int64     `json:"bytes"`
+	// TopDownloads are the most downloaded files.
+	TopDownloads []fileStats `json:"top_downloads"`
+	// Errors counts the error responses by status code.
+	Errors map[string]int `json:"errors"`
+}
+
+// summary returns the summary of the requests and the downloads in stats.
+func (c *requestCounter) summary(stats *downloadStats) shareSummary {
+	files := stats.list()
+	if files == nil {
+		files = []fileStats{}
+	}
+	downloads, _ := stats.totals()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sum := shareSummary{
+		Started:      c.started,
+		Uptime:       time.Since(c.started).Seconds(),
+		Clients:      len(c.clients),
+		Requests:     c.requests,
+		Files:        len(files),
+		Downloads:    downloads,
+		Bytes:        c.sent,
+		TopDownloads: files[:min(len(files), topDownloads)],
+		Errors:       make(map[string]int),
+	}
+	for code, n := range c.errors {
+		sum.Errors[strconv.Itoa(code)] = n
+	}
+	return sum
+}
+
+// print logs the summary.
+func (s shareSummary) print() {
+	uptime := time.Duration(s.Uptime * float64(time.Second)).Round(time.Second)
+	log.Printf("served %d requests from %d clients in %s, %s sent", s.Requests, s.Clients, uptime, formatSize(s.Bytes))
+	if s.Downloads == 0 {
+		log.Println("no files were downloaded")
+	} else {
+		log.Printf("%d downloads of %d files, most downloaded:", s.Downloads, s.Files)
+		for _, f := range s.TopDownloads {
+			log.Printf("  %s: %d downloads, %s", f.Path, f.Downloads, formatSize(f.Bytes))
+		}
+	}
+	if len(s.Errors) > 0 {
+		var parts []string
+		for _, code := range slices.Sorted(maps.Keys(s.Errors)) {
+			parts = append(parts, fmt.Sprintf("%d with %s", s.Errors[code], code))
+		}
+		log.Printf("errors: %s", strings.Join(parts, ", "))
+	}
+}
+
+// write saves the summary as JSON to f, which is opened on start so it
+// can be written in the -sandbox too.
+func (s shareSummary) write(f *os.File) error {
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(s); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
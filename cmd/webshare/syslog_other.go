@@ -0,0 +1,13 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+// openSyslog is only implemented on unix systems.
+func openSyslog(facility, tag string) (io.Writer, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
@@ -0,0 +1,53 @@
+//go:build tailscale
+
+package main
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"tailscale.com/tsnet"
+)
+
+// tailnetNode puts the share on a tailnet as a node of its own, so it is
+// only reachable by the devices on it, or the internet with funnel.
+type tailnetNode struct {
+	srv *tsnet.Server
+	// dnsName is the MagicDNS name of the node.
+	dnsName string
+}
+
+// joinTailnet starts a node with the host name, logging in with
+// $TS_AUTHKEY or a link printed to the log on first run. The node state is
+// kept in the user config directory.
+func joinTailnet(ctx context.Context, hostname string) (*tailnetNode, error) {
+	dir, err := configFile("tailscale")
+	if err != nil {
+		return nil, err
+	}
+	srv := &tsnet.Server{Hostname: hostname, Dir: filepath.Join(dir, hostname)}
+	status, err := srv.Up(ctx)
+	if err != nil {
+		srv.Close()
+		return nil, err
+	}
+	return &tailnetNode{srv: srv, dnsName: strings.TrimSuffix(status.Self.DNSName, ".")}, nil
+}
+
+// listen listens on the port of the node.
+func (n *tailnetNode) listen(port int) (net.Listener, error) {
+	return n.srv.Listen("tcp", ":"+strconv.Itoa(port))
+}
+
+// funnel listens for https from the internet on port 443 of the node. The
+// tailnet needs https certificates and funnel enabled.
+func (n *tailnetNode) funnel() (net.Listener, error) {
+	return n.srv.ListenFunnel("tcp", ":443", tsnet.FunnelOnly())
+}
+
+func (n *tailnetNode) close() error {
+	return n.srv.Close()
+}
@@ -0,0 +1,31 @@
+//go:build !tailscale
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+// tailnetNode is only available in builds with -tags tailscale, which keep
+// the tailscale dependencies out of the default binary.
+type tailnetNode struct {
+	dnsName string
+}
+
+func joinTailnet(ctx context.Context, hostname string) (*tailnetNode, error) {
+	return nil, errors.New("not supported by this build, rebuild with -tags tailscale")
+}
+
+func (n *tailnetNode) listen(port int) (net.Listener, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func (n *tailnetNode) funnel() (net.Listener, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func (n *tailnetNode) close() error {
+	return nil
+}
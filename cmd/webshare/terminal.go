@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"golang.org/x/term"
+)
+
+// hyperlinks reports whether the log goes to a terminal that may show
+// links as clickable, and not as json or to syslog.
+var hyperlinks = sync.OnceValue(func() bool {
+	return log.Writer() == os.Stderr && term.IsTerminal(int(os.Stderr.Fd())) && os.Getenv("TERM") != "dumb"
+})
+
+// termLink returns link as an OSC 8 hyperlink on terminals, which show it
+// as is if they do not support them.
+func termLink(link string) string {
+	if !hyperlinks() {
+		return link
+	}
+	return "\x1b]8;;" + link + "\x1b\\" + link + "\x1b]8;;\x1b\\"
+}
+
+// clipboardCommands put their standard input on the clipboard, in the
+// order they are tried, if the environment variable for their display is
+// set.
+var clipboardCommands = []struct {
+	display string
+	args    []string
+}{
+	{"WAYLAND_DISPLAY", []string{"wl-copy"}},
+	{"DISPLAY", []string{"xclip", "-selection", "clipboard"}},
+	{"DISPLAY", []string{"xsel", "--clipboard", "--input"}},
+	{"", []string{"pbcopy"}},
+	{"", []string{"clip.exe"}},
+}
+
+// copyToClipboard puts text on the system clipboard with the first of
+// clipboardCommands found. Without any, it asks the terminal with OSC 52,
+// which also works over ssh in many terminals.
+func copyToClipboard(text string) error {
+	for _, c := range clipboardCommands {
+		if c.display != "" && os.Getenv(c.display) == "" {
+			continue
+		}
+		args := c.args
+		if _, err := exec.LookPath(args[0]); err != nil {
+			continue
+		}
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stdin = strings.NewReader(text)
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%s: %w", args[0], err)
+		}
+		return nil
+	}
+	if !term.IsTerminal(int(os.Stdout.Fd())) {
+		return errors.New("no clipboard command found and not on a terminal")
+	}
+	fmt.Printf("\x1b]52;c;%s\x07", base64.StdEncoding.EncodeToString([]byte(text)))
+	return nil
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	tftpRRQ   = 1
+	tftpWRQ   = 2
+	tftpDATA  = 3
+	tftpACK   = 4
+	tftpERROR = 5
+	tftpOACK  = 6
+
+	tftpRetries = 5
+	tftpTimeout = 2 * time.Second
+)
+
+// serveTFTP runs a tftp server (RFC 1350, with the blksize and tsize
+// options used by most netboot firmware) for dir on conn until ctx is done.
+func serveTFTP(ctx context.Context, conn net.PacketConn, dir string, writable bool) error {
+	log.Printf("tftp listening on %s", conn.LocalAddr())
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	buf := make([]byte, 1500)
+	for {
+		n, raddr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if n < 4 {
+			continue
+		}
+		if !clientAllowed(raddr) {
+			log.Printf("tftp %s: denied access", raddr)
+			continue
+		}
+		packet := append([]byte(nil), buf[:n]...)
+		go handleTFTP(packet, raddr, dir, writable)
+	}
+}
+
+// handleTFTP answers a single read or write request from a fresh port, as
+// the protocol requires.
+func handleTFTP(packet []byte, raddr net.Addr, dir string, writable bool) {
+	conn, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		log.Printf("tftp %s: %v", raddr, err)
+		return
+	}
+	defer conn.Close()
+	opcode := binary.BigEndian.Uint16(packet)
+	fields := strings.Split(string(packet[2:]), "\x00")
+	if len(fields) < 2 {
+		tftpError(conn, raddr, 4, "malformed request")
+		return
+	}
+	name, options := fields[0], map[string]string{}
+	for i := 2; i+1 < len(fields); i += 2 {
+		options[strings.ToLower(fields[i])] = fields[i+1]
+	}
+	switch opcode {
+	case tftpRRQ:
+		log.Println(raddr, "tftp get", name)
+		if _, err := policy.stat(dir, name); err != nil {
+			tftpError(conn, raddr, 2, "access denied")
+			return
+		}
+		err = tftpSend(conn, raddr, localPath(dir, name), options)
+	case tftpWRQ:
+		if !writable {
+			tftpError(conn, raddr, 2, "read-only share")
+			return
+		}
+		log.Println(raddr, "tftp put", name)
+		err = tftpReceive(conn, raddr, dir, name, options)
+	default:
+		tftpError(conn, raddr, 4, "illegal operation")
+		return
+	}
+	if err != nil {
+		log.Printf("tftp %s %s: %v", raddr, name, err)
+	}
+}
+
+func tftpError(conn net.PacketConn, raddr net.Addr, code uint16, msg string) {
+	b := make([]byte, 4, 5+len(msg))
+	binary.BigEndian.PutUint16(b, tftpERROR)
+	binary.BigEndian.PutUint16(b[2:], code)
+	b = append(append(b, msg...), 0)
+	conn.WriteTo(b, raddr)
+}
+
+// negotiate returns the block size to use and the option acknowledgement to
+// send, if the client asked for any supported options.
+func negotiate(options map[string]string, size int64) (int, []byte) {
+	blksize := 512
+	var oack bytes.Buffer
+	if v, ok := options["blksize"]; ok {
+		if n, err := strconv.Atoi(v); err == nil && n >= 8 {
+			blksize = min(n, 1428)
+			fmt.Fprintf(&oack, "blksize\x00%d\x00", blksize)
+		}
+	}
+	if _, ok := options["tsize"]; ok && size >= 0 {
+		fmt.Fprintf(&oack, "tsize\x00%d\x00", size)
+	}
+	if oack.Len() == 0 {
+		return blksize, nil
+	}
+	return blksize, append([]byte{0, tftpOACK}, oack.Bytes()...)
+}
+
+// exchange sends b and waits for an ack of block, retransmitting on timeout.
+func exchange(conn net.PacketConn, raddr net.Addr, b []byte, block uint16) error {
+	ack := make([]byte, 512)
+	for i := 0; i < tftpRetries; i++ {
+		if _, err := conn.WriteTo(b, raddr); err != nil {
+			return err
+		}
+		deadline := time.Now().Add(tftpTimeout)
+		for {
+			conn.SetReadDeadline(deadline)
+			n, from, err := conn.ReadFrom(ack)
+			if err != nil {
+				var ne net.Error
+				if errors.As(err, &ne) && ne.Timeout() {
+					break
+				}
+				return err
+			}
+			if from.String() != raddr.String() || n < 4 {
+				continue
+			}
+			switch binary.BigEndian.Uint16(ack) {
+			case tftpACK:
+				if binary.BigEndian.Uint16(ack[2:]) == block {
+					return nil
+				}
+			case tftpERROR:
+				return fmt.Errorf("client error: %s", bytes.TrimRight(ack[4:n], "\x00"))
+			}
+		}
+	}
+	return errors.New("timeout")
+}
+
+func tftpSend(conn net.PacketConn, raddr net.Addr, fn string, options map[string]string) error {
+	f, err := os.Open(fn)
+	if err != nil {
+		tftpError(conn, raddr, 1, "file not found")
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		tftpError(conn, raddr, 1, "file not found")
+		return err
+	}
+	blksize, oack := negotiate(options, fi.Size())
+	if oack != nil {
+		if err := exchange(conn, raddr, oack, 0); err != nil {
+			return err
+		}
+	}
+	buf := make([]byte, 4+blksize)
+	binary.BigEndian.PutUint16(buf, tftpDATA)
+	for block := uint16(1); ; block++ {
+		n, err := io.ReadFull(f, buf[4:])
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			tftpError(conn, raddr, 0, "read error")
+			return err
+		}
+		binary.BigEndian.PutUint16(buf[2:], block)
+		if err := exchange(conn, raddr, buf[:4+n], block); err != nil {
+			return err
+		}
+		if n < blksize {
+			return nil
+		}
+	}
+}
+
+func tftpReceive(conn net.PacketConn, raddr net.Addr, dir, name string, options map[string]string) error {
+	fn, err := resolvePath(dir, name)
+	if err != nil {
+		tftpError(conn, raddr, 2, err.Error())
+		return err
+	}
+	size := int64(-1)
+	if v, err := strconv.ParseInt(options["tsize"], 10, 64); err == nil {
+		size = v
+	}
+	blksize, oack := negotiate(options, size)
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		_, err := writeFile(fn, pr)
+		pr.CloseWithError(err)
+		done <- err
+	}()
+	reply := oack
+	if reply == nil {
+		reply = []byte{0, tftpACK, 0, 0}
+	}
+	buf := make([]byte, 4+blksize)
+	for block := uint16(1); ; block++ {
+		n, err := receiveBlock(conn, raddr, reply, buf, block)
+		if err != nil {
+			pw.CloseWithError(err)
+			<-done
+			return err
+		}
+		if _, err := pw.Write(buf[4:n]); err != nil {
+			tftpError(conn, raddr, 3, "write error")
+			return err
+		}
+		reply = []byte{0, tftpACK, byte(block >> 8), byte(block)}
+		if n-4 < blksize {
+			pw.Close()
+			if err := <-done; err != nil {
+				tftpError(conn, raddr, 3, "write error")
+				return err
+			}
+			_, err := conn.WriteTo(reply, raddr)
+			return err
+		}
+	}
+}
+
+// receiveBlock sends reply until the data packet for block arrives in buf
+// and returns the length of the packet.
+func receiveBlock(conn net.PacketConn, raddr net.Addr, reply, buf []byte, block uint16) (int, error) {
+	for i := 0; i < tftpRetries; i++ {
+		if _, err := conn.WriteTo(reply, raddr); err != nil {
+			return 0, err
+		}
+		deadline := time.Now().Add(tftpTimeout)
+		for {
+			conn.SetReadDeadline(deadline)
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				var ne net.Error
+				if errors.As(err, &ne) && ne.Timeout() {
+					break
+				}
+				return 0, err
+			}
+			if from.String() != raddr.String() || n < 4 {
+				continue
+			}
+			switch binary.BigEndian.Uint16(buf) {
+			case tftpDATA:
+				if binary.BigEndian.Uint16(buf[2:]) == block {
+					return n, nil
+				}
+			case tftpERROR:
+				return 0, fmt.Errorf("client error: %s", bytes.TrimRight(buf[4:n], "\x00"))
+			}
+		}
+	}
+	return 0, errors.New("timeout")
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"golang.org/x/time/rate"
+)
+
+// throttleChunk is the most a throttled writer sends at once, so transfers
+// sharing a limit take turns.
+const throttleChunk = 32 << 10
+
+// byteRate is a flag value for transfer rates like 5MB/s, see byteSize.
+type byteRate int64
+
+func (b *byteRate) String() string {
+	return formatSize(int64(*b)) + "/s"
+}
+
+func (b *byteRate) Set(v string) error {
+	n, err := parseSize(strings.TrimSuffix(strings.TrimSpace(v), "/s"))
+	if err != nil {
+		return fmt.Errorf("invalid rate: %q", v)
+	}
+	*b = byteRate(n)
+	return nil
+}
+
+// newLimiter returns a limiter for rate bytes per second, or nil for none.
+func newLimiter(r byteRate) *rate.Limiter {
+	if r <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(r), throttleChunk)
+}
+
+type connLimiterKey struct{}
+
+// throttle limits response bodies to a global rate and to a rate per
+// connection.
+type throttle struct {
+	global  *rate.Limiter
+	perConn byteRate
+}
+
+// ConnContext gives every connection its own limiter, for http.Server.
+func (t *throttle) ConnContext(ctx context.Context, c net.Conn) context.Context {
+	if t.perConn <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, connLimiterKey{}, newLimiter(t.perConn))
+}
+
+// Handler wraps h so that response bodies are sent no faster than allowed.
+func (t *throttle) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var limiters []*rate.Limiter
+		if t.global != nil {
+			limiters = append(limiters, t.global)
+		}
+		if l, ok := r.Context().Value(connLimiterKey{}).(*rate.Limiter); ok {
+			limiters = append(limiters, l)
+		}
+		h.ServeHTTP(&throttleWriter{ResponseWriter: w, ctx: r.Context(), limiters: limiters}, r)
+	})
+}
+
+// throttleWriter is a ResponseWriter that waits for all its limiters before
+// each chunk it writes.
+type throttleWriter struct {
+	http.ResponseWriter
+	ctx      context.Context
+	limiters []*rate.Limiter
+}
+
+func (tw *throttleWriter) wait(n int) error {
+	for _, l := range tw.limiters {
+		if err := l.WaitN(tw.ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tw *throttleWriter) Write(p []byte) (int, error) {
+	var total int
+	for len(p) > 0 {
+		chunk := p[:min(len(p), throttleChunk)]
+		if err := tw.wait(len(chunk)); err != nil {
+			return total, err
+		}
+		n, err := tw.ResponseWriter.Write(chunk)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		p = p[n:]
+	}
+	return total, nil
+}
+
+// ReadFrom keeps the sendfile path of the wrapped writer, one chunk at a
+// time.
+func (tw *throttleWriter) ReadFrom(r io.Reader) (int64, error) {
+	var total int64
+	for {
+		if err := tw.wait(throttleChunk); err != nil {
+			return total, err
+		}
+		n, err := readChunk(tw.ResponseWriter, r, throttleChunk)
+		total += n
+		if err != nil || n < throttleChunk {
+			return total, err
+		}
+	}
+}
+
+func (tw *throttleWriter) Flush() {
+	if f, ok := tw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (tw *throttleWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
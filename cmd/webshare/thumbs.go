@@ -0,0 +1,197 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/jpeg"
+	_ "image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	thumbSize = 256
+	// Larger images are not thumbnailed, decoding them costs too much memory.
+	thumbMaxPixels = 50_000_000
+)
+
+// isThumbable reports whether a thumbnail can be generated for name.
+func isThumbable(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".jpg", ".jpeg", ".png", ".webp":
+		return true
+	}
+	return false
+}
+
+// thumbnailer generates small jpeg previews of images on demand and keeps
+// them in a cache directory, which is trimmed to maxSize bytes.
+type thumbnailer struct {
+	dir     string
+	cache   string
+	maxSize int64
+	// sem limits concurrent decoding, which is memory and cpu heavy.
+	sem chan struct{}
+	mu  sync.Mutex
+}
+
+func newThumbnailer(dir, cache string, maxSize int64) *thumbnailer {
+	return &thumbnailer{dir: dir, cache: cache, maxSize: maxSize, sem: make(chan struct{}, 2)}
+}
+
+// thumbCacheDir returns the thumbnail cache in the user cache directory,
+// creating it if needed. A cache that another user owns or can write to is
+// refused, since its thumbnails would be served as they are.
+func thumbCacheDir() (string, error) {
+	base, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	cache := filepath.Join(base, "webshare", "thumbs")
+	if err := os.MkdirAll(cache, 0700); err != nil {
+		return "", err
+	}
+	fi, err := os.Lstat(cache)
+	if err != nil {
+		return "", err
+	}
+	if !fi.IsDir() || !privateDir(fi) {
+		return "", fmt.Errorf("%s is not a directory of this user only", cache)
+	}
+	return cache, nil
+}
+
+// Handler wraps h and answers requests with a thumb query parameter on
+// image files with a thumbnail.
+func (t *thumbnailer) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.URL.Query()["thumb"]; !ok || !isThumbable(r.URL.Path) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		fn := localPath(t.dir, r.URL.Path)
+		fi, err := os.Stat(fn)
+		if err != nil || !fi.Mode().IsRegular() {
+			http.NotFound(w, r)
+			return
+		}
+		thumb, err := t.thumbnail(fn, fi)
+		if err != nil {
+			logRequest(r, "thumbnail %s: %v", fn, err)
+			http.Error(w, "no thumbnail available", http.StatusUnprocessableEntity)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=86400")
+		http.ServeFile(w, r, thumb)
+	})
+}
+
+// thumbnail returns the path to the cached thumbnail of fn, creating it if
+// necessary. The cache key includes size and modification time, so changed
+// images get new thumbnails.
+func (t *thumbnailer) thumbnail(fn string, fi os.FileInfo) (string, error) {
+	abs, err := filepath.Abs(fn)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%d\x00%d", abs, fi.Size(), fi.ModTime().UnixNano())))
+	thumb := filepath.Join(t.cache, hex.EncodeToString(sum[:16])+".jpg")
+	if _, err := os.Stat(thumb); err == nil {
+		now := time.Now()
+		os.Chtimes(thumb, now, now)
+		return thumb, nil
+	}
+	t.sem <- struct{}{}
+	defer func() { <-t.sem }()
+	if err := t.generate(fn, thumb); err != nil {
+		return "", err
+	}
+	t.trim()
+	return thumb, nil
+}
+
+func (t *thumbnailer) generate(fn, thumb string) error {
+	f, err := os.Open(fn)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return err
+	}
+	if cfg.Width*cfg.Height > thumbMaxPixels {
+		return fmt.Errorf("image too large: %dx%d", cfg.Width, cfg.Height)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return err
+	}
+	src, _, err := image.Decode(f)
+	if err != nil {
+		return err
+	}
+	b := src.Bounds()
+	w, h := thumbSize, thumbSize
+	if b.Dx() > b.Dy() {
+		h = max(1, b.Dy()*thumbSize/b.Dx())
+	} else {
+		w = max(1, b.Dx()*thumbSize/b.Dy())
+	}
+	if b.Dx() <= w && b.Dy() <= h {
+		w, h = b.Dx(), b.Dy()
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.ApproxBiLinear.Scale(dst, dst.Bounds(), src, b, draw.Src, nil)
+	tmp, err := os.CreateTemp(t.cache, ".thumb-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := jpeg.Encode(tmp, dst, &jpeg.Options{Quality: 80}); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), thumb)
+}
+
+// trim removes the least recently used thumbnails until the cache fits.
+func (t *thumbnailer) trim() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	entries, err := os.ReadDir(t.cache)
+	if err != nil {
+		return
+	}
+	var (
+		infos []os.FileInfo
+		total int64
+	)
+	for _, e := range entries {
+		if fi, err := e.Info(); err == nil && fi.Mode().IsRegular() {
+			infos = append(infos, fi)
+			total += fi.Size()
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ModTime().Before(infos[j].ModTime()) })
+	for _, fi := range infos {
+		if total <= t.maxSize {
+			return
+		}
+		if os.Remove(filepath.Join(t.cache, fi.Name())) == nil {
+			total -= fi.Size()
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+func serialNumber() *big.Int {
+	n, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// localNames returns the names and addresses this machine can be reached
+// under, for the subject alternative names of server certificates.
+func localNames() ([]string, []net.IP) {
+	names := []string{"localhost"}
+	if name, err := os.Hostname(); err == nil {
+		names = append(names, name)
+	}
+	var ips []net.IP
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok {
+				ips = append(ips, ipnet.IP)
+			}
+		}
+	}
+	return names, ips
+}
+
+// selfSignedCert generates a server certificate for the local names and
+// addresses, which only lives as long as the process.
+func selfSignedCert() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	names, ips := localNames()
+	template := &x509.Certificate{
+		SerialNumber: serialNumber(),
+		Subject:      pkix.Name{CommonName: "webshare"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(0, 1, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     names,
+		IPAddresses:  ips,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, nil
+}
+
+// fingerprint returns the SHA-256 fingerprint of a certificate, the way
+// browsers show it.
+func fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return fmt.Sprintf("% X", sum[:])
+}
+
+// certLoader serves a certificate read from files, which can be reloaded
+// while running, e.g. after it was renewed.
+type certLoader struct {
+	certFile, keyFile, password string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+// load reads the certificate, from a PKCS#12 bundle if certFile ends in .p12
+// or .pfx, otherwise from PEM files. Without keyFile the key is expected in
+// certFile.
+func (l *certLoader) load() error {
+	var cert tls.Certificate
+	switch ext := strings.ToLower(filepath.Ext(l.certFile)); ext {
+	case ".p12", ".pfx":
+		b, err := os.ReadFile(l.certFile)
+		if err != nil {
+			return err
+		}
+		key, leaf, chain, err := pkcs12.DecodeChain(b, l.password)
+		if err != nil {
+			return fmt.Errorf("%s: %w", l.certFile, err)
+		}
+		if _, ok := key.(crypto.Signer); !ok {
+			return fmt.Errorf("%s: unsupported key type %T", l.certFile, key)
+		}
+		cert = tls.Certificate{Certificate: [][]byte{leaf.Raw}, PrivateKey: key, Leaf: leaf}
+		for _, c := range chain {
+			cert.Certificate = append(cert.Certificate, c.Raw)
+		}
+	default:
+		keyFile := l.keyFile
+		if keyFile == "" {
+			keyFile = l.certFile
+		}
+		var err error
+		if cert, err = tls.LoadX509KeyPair(l.certFile, keyFile); err != nil {
+			return err
+		}
+	}
+	if cert.Leaf == nil {
+		return errors.New("no certificate found")
+	}
+	name := strings.Join(cert.Leaf.DNSNames, ", ")
+	if name == "" {
+		name = cert.Leaf.Subject.CommonName
+	}
+	log.Printf("loaded certificate for %s, valid until %s", name, cert.Leaf.NotAfter.Format(time.DateOnly))
+	l.mu.Lock()
+	l.cert = &cert
+	l.mu.Unlock()
+	return nil
+}
+
+// GetCertificate is for tls.Config.
+func (l *certLoader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.cert, nil
+}
+
+// redirectHandler sends plain http requests to the same URL over https on
+// port.
+func redirectHandler(port int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.Trim(host, "[]")
+		if port != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(port))
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
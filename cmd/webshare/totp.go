@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	totpStep       = 30 * time.Second
+	totpSession    = 12 * time.Hour
+	totpCookie     = "webshare_session"
+	totpMaxFailed  = 5
+	totpLockout    = time.Minute
+	totpCodeLength = 6
+)
+
+//go:embed assets/totp.html
+var totpPage string
+
+var totpTemplate = template.Must(template.New("totp").Parse(totpPage))
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// loadTOTPSecret reads the shared TOTP secret from the configuration
+// directory, generating one on first use, so authenticator apps only have
+// to be set up once.
+func loadTOTPSecret() ([]byte, error) {
+	fn, err := configFile("totp_secret")
+	if err != nil {
+		return nil, err
+	}
+	if b, err := os.ReadFile(fn); err == nil {
+		return totpEncoding.DecodeString(strings.TrimSpace(string(b)))
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(fn), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(fn, []byte(totpEncoding.EncodeToString(secret)+"\n"), 0600); err != nil {
+		return nil, err
+	}
+	log.Printf("generated totp secret at %s", fn)
+	return secret, nil
+}
+
+// totpGate requires a valid code from an authenticator app (RFC 6238)
+// before any request is passed on, and remembers clients that entered one
+// with a session cookie.
+type totpGate struct {
+	secret []byte
+
+	mu       sync.Mutex
+	sessions map[string]time.Time
+	// lastUsed is the last accepted time step, so codes cannot be replayed.
+	lastUsed    uint64
+	failed      int
+	lockedUntil time.Time
+}
+
+func newTOTPGate(secret []byte) *totpGate {
+	return &totpGate{secret: secret, sessions: make(map[string]time.Time)}
+}
+
+// provisioningURI returns the otpauth URI to set up an authenticator app.
+func (g *totpGate) provisioningURI() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	q := url.Values{}
+	q.Set("secret", totpEncoding.EncodeToString(g.secret))
+	q.Set("issuer", "webshare")
+	u := url.URL{Scheme: "otpauth", Host: "totp", Path: "/webshare", RawQuery: q.Encode()}
+	return u.String()
+}
+
+// setSecret replaces the secret and ends all sessions if it changed. It
+// reports whether it did.
+func (g *totpGate) setSecret(secret []byte) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if bytes.Equal(secret, g.secret) {
+		return false
+	}
+	g.secret, g.sessions, g.lastUsed = secret, make(map[string]time.Time), 0
+	return true
+}
+
+// code returns the code for time step counter.
+func (g *totpGate) code(counter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	h := hmac.New(sha1.New, g.secret)
+	h.Write(msg[:])
+	sum := h.Sum(nil)
+	off := sum[len(sum)-1] & 0xf
+	v := binary.BigEndian.Uint32(sum[off:]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpCodeLength, v%1000000)
+}
+
+// check validates code, allowing one step of clock drift either way.
+func (g *totpGate) check(code string) (bool, string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	if now.Before(g.lockedUntil) {
+		return false, "Too many wrong codes, try again in a minute."
+	}
+	counter := uint64(now.Unix() / int64(totpStep/time.Second))
+	for _, c := range []uint64{counter, counter - 1, counter + 1} {
+		if c > g.lastUsed && hmac.Equal([]byte(code), []byte(g.code(c))) {
+			g.lastUsed, g.failed = c, 0
+			return true, ""
+		}
+	}
+	if g.failed++; g.failed >= totpMaxFailed {
+		g.failed, g.lockedUntil = 0, now.Add(totpLockout)
+	}
+	return false, "Wrong code."
+}
+
+func (g *totpGate) newSession() string {
+	id := randomToken(20)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	for s, exp := range g.sessions {
+		if now.After(exp) {
+			delete(g.sessions, s)
+		}
+	}
+	g.sessions[id] = now.Add(totpSession)
+	return id
+}
+
+func (g *totpGate) validSession(r *http.Request) bool {
+	c, err := r.Cookie(totpCookie)
+	if err != nil {
+		return false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	exp, ok := g.sessions[c.Value]
+	return ok && time.Now().Before(exp)
+}
+
+// Handler wraps h and answers requests without a valid session with a form
+// for the code, which is posted back to the same URL.
+func (g *totpGate) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if g.validSession(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		var msg string
+		if r.Method == http.MethodPost && strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+			var ok bool
+			if ok, msg = g.check(r.PostFormValue("totp_code")); ok {
+				http.SetCookie(w, &http.Cookie{
+					Name:     totpCookie,
+					Value:    g.newSession(),
+					Path:     "/",
+					MaxAge:   int(totpSession / time.Second),
+					HttpOnly: true,
+					SameSite: http.SameSiteLaxMode,
+				})
+				logRequest(r, "%s totp session started", r.RemoteAddr)
+				http.Redirect(w, r, r.URL.RequestURI(), http.StatusSeeOther)
+				return
+			}
+			logRequest(r, "%s totp code rejected", r.RemoteAddr)
+			emitAuthFailure(r, "totp code rejected")
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusUnauthorized)
+		totpTemplate.Execute(w, msg)
+	})
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tracer exports a span per request to an OpenTelemetry collector, with
+// the JSON encoding of OTLP over http.
+type tracer struct {
+	endpoint string
+	client   http.Client
+	// token is the secret path prefix, left out of the spans.
+	token *secretPrefix
+
+	mu    sync.Mutex
+	spans []otlpSpan
+	// flush asks the export loop to send the spans right away.
+	flush chan struct{}
+	done  chan struct{}
+}
+
+// maxBatch is the number of spans sent at the latest in one request.
+const maxBatch = 512
+
+type otlpAttribute struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes"`
+	Status            struct {
+		Code int `json:"code,omitempty"`
+	} `json:"status"`
+}
+
+func stringAttr(key, value string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: map[string]any{"stringValue": value}}
+}
+
+// intAttr holds an int64, which is a string in the JSON encoding.
+func intAttr(key string, value int64) otlpAttribute {
+	return otlpAttribute{Key: key, Value: map[string]any{"intValue": strconv.FormatInt(value, 10)}}
+}
+
+// newTracer exports spans to the collector at endpoint, e.g.
+// http://localhost:4318, until ctx is done.
+func newTracer(ctx context.Context, endpoint string, token *secretPrefix) *tracer {
+	if !strings.HasSuffix(endpoint, "/v1/traces") {
+		endpoint = strings.TrimSuffix(endpoint, "/") + "/v1/traces"
+	}
+	t := &tracer{
+		endpoint: endpoint,
+		token:    token,
+		client:   http.Client{Timeout: 10 * time.Second},
+		flush:    make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	go t.run(ctx)
+	return t
+}
+
+// run sends the spans every few seconds, and the remaining ones once ctx
+// is done.
+func (t *tracer) run(ctx context.Context) {
+	defer close(t.done)
+	tick := time.NewTicker(5 * time.Second)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+		case <-t.flush:
+		case <-ctx.Done():
+			t.export()
+			return
+		}
+		t.export()
+	}
+}
+
+// close waits for the last spans to be sent.
+func (t *tracer) close() {
+	<-t.done
+}
+
+// export sends the collected spans in batches.
+func (t *tracer) export() {
+	t.mu.Lock()
+	spans := t.spans
+	t.spans = nil
+	t.mu.Unlock()
+	for len(spans) > 0 {
+		n := min(len(spans), maxBatch)
+		if err := t.send(spans[:n]); err != nil {
+			log.Printf("otel: dropped %d spans: %v", n, err)
+		}
+		spans = spans[n:]
+	}
+}
+
+func (t *tracer) send(spans []otlpSpan) error {
+	type scopeSpans struct {
+		Scope struct {
+			Name string `json:"name"`
+		} `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	type resourceSpans struct {
+		Resource struct {
+			Attributes []otlpAttribute `json:"attributes"`
+		} `json:"resource"`
+		ScopeSpans []scopeSpans `json:"scopeSpans"`
+	}
+	var rs resourceSpans
+	rs.Resource.Attributes = []otlpAttribute{stringAttr("service.name", "webshare")}
+	ss := scopeSpans{Spans: spans}
+	ss.Scope.Name = "webshare"
+	rs.ScopeSpans = []scopeSpans{ss}
+	b, err := json.Marshal(map[string]any{"resourceSpans": []resourceSpans{rs}})
+	if err != nil {
+		return err
+	}
+	resp, err := t.client.Post(t.endpoint, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", t.endpoint, resp.Status)
+	}
+	return nil
+}
+
+// randomHex returns n random bytes in hex.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// parseTraceparent returns the trace and parent span id of a W3C
+// traceparent header, or empty strings.
+func parseTraceparent(v string) (traceID, spanID string) {
+	parts := strings.Split(v, "-")
+	if len(parts) < 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return "", ""
+	}
+	if _, err := hex.DecodeString(parts[1] + parts[2]); err != nil {
+		return "", ""
+	}
+	return strings.ToLower(parts[1]), strings.ToLower(parts[2])
+}
+
+// Handler wraps h and records a span for each request, continuing the
+// trace of the client if it sent one.
+func (t *tracer) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &responseRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r)
+		end := time.Now()
+		span := otlpSpan{
+			SpanID:            randomHex(8),
+			Name:              r.Method,
+			Kind:              2, // server
+			StartTimeUnixNano: strconv.FormatInt(start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(end.UnixNano(), 10),
+		}
+		span.TraceID, span.ParentSpanID = parseTraceparent(r.Header.Get("Traceparent"))
+		if span.TraceID == "" {
+			span.TraceID = randomHex(16)
+		}
+		client := r.RemoteAddr
+		if host, _, err := net.SplitHostPort(client); err == nil {
+			client = host
+		}
+		span.Attributes = []otlpAttribute{
+			stringAttr("http.request.method", r.Method),
+			stringAttr("url.path", strings.TrimPrefix(r.URL.Path, t.token.get())),
+			intAttr("http.response.status_code", int64(rec.Status())),
+			intAttr("http.response.body.size", rec.written),
+			stringAttr("client.address", client),
+			stringAttr("user_agent.original", r.UserAgent()),
+			stringAttr("network.protocol.version", strings.TrimPrefix(r.Proto, "HTTP/")),
+		}
+		if rec.Status() >= 500 {
+			span.Status.Code = 2 // error
+		}
+		t.mu.Lock()
+		t.spans = append(t.spans, span)
+		full := len(t.spans) >= maxBatch
+		t.mu.Unlock()
+		if full {
+			select {
+			case t.flush <- struct{}{}:
+			default:
+			}
+		}
+	})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// responseRecorder wraps a ResponseWriter and records the status code, the
+// number of body bytes written and whether writing failed, e.g. because the
+// client went away.
+type responseRecorder struct {
+	http.ResponseWriter
+	status  int
+	written int64
+	err     error
+}
+
+func (rr *responseRecorder) WriteHeader(code int) {
+	if rr.status == 0 {
+		rr.status = code
+	}
+	rr.ResponseWriter.WriteHeader(code)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+	n, err := rr.ResponseWriter.Write(b)
+	rr.written += int64(n)
+	if err != nil && rr.err == nil {
+		rr.err = err
+	}
+	return n, err
+}
+
+// ReadFrom keeps the sendfile fast path of the wrapped writer available.
+func (rr *responseRecorder) ReadFrom(r io.Reader) (int64, error) {
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+	var (
+		n   int64
+		err error
+	)
+	if rf, ok := rr.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(r)
+	} else {
+		n, err = io.Copy(rr.ResponseWriter, r)
+	}
+	rr.written += n
+	if err != nil && rr.err == nil {
+		rr.err = err
+	}
+	return n, err
+}
+
+// readChunk copies at most n bytes of r to w, via the ReadFrom of w if it
+// has one. A limited reader is limited further instead of wrapped, since
+// sendfile only looks through one of them for the file.
+func readChunk(w io.Writer, r io.Reader, n int64) (int64, error) {
+	chunk := &io.LimitedReader{R: r, N: n}
+	lr, limited := r.(*io.LimitedReader)
+	if limited {
+		chunk.R, chunk.N = lr.R, min(n, lr.N)
+	}
+	var (
+		written int64
+		err     error
+	)
+	if rf, ok := w.(io.ReaderFrom); ok {
+		written, err = rf.ReadFrom(chunk)
+	} else {
+		written, err = io.Copy(w, chunk)
+	}
+	if limited {
+		lr.N -= written
+	}
+	return written, err
+}
+
+func (rr *responseRecorder) Flush() {
+	if f, ok := rr.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap allows http.ResponseController to reach the original writer.
+func (rr *responseRecorder) Unwrap() http.ResponseWriter {
+	return rr.ResponseWriter
+}
+
+// Status returns the response status, defaulting to 200 like net/http does.
+func (rr *responseRecorder) Status() int {
+	if rr.status == 0 {
+		return http.StatusOK
+	}
+	return rr.status
+}
+
+// isDownload reports whether the recorded response was a complete file
+// transfer: a full 200 response to a GET that either sent exactly the
+// announced length or was explicitly served as an attachment, like the
+// directory archives. Listings and forms fall into neither category, nor
+// do thumbnails.
+func (rr *responseRecorder) isDownload(r *http.Request) bool {
+	if r.Method != http.MethodGet || rr.Status() != http.StatusOK || rr.err != nil || auxiliary(r) {
+		return false
+	}
+	h := rr.Header()
+	if cl := h.Get("Content-Length"); cl != "" {
+		n, err := strconv.ParseInt(cl, 10, 64)
+		return err == nil && n == rr.written
+	}
+	return strings.HasPrefix(h.Get("Content-Disposition"), "attachment")
+}
+
+// auxiliary reports whether r asks for something derived from a file,
+// like its thumbnail, which is not a download of the file.
+func auxiliary(r *http.Request) bool {
+	_, thumb := r.URL.Query()["thumb"]
+	return thumb && isThumbable(r.URL.Path)
+}
+
+// aborted reports whether the client went away before the response was
+// sent completely.
+func (rr *responseRecorder) aborted(r *http.Request) bool {
+	return rr.err != nil || r.Context().Err() != nil
+}
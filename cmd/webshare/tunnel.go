@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// tunnel makes the share reachable from the internet through a server
+// outside the local network.
+type tunnel interface {
+	// open starts the tunnel to the local address and returns the public
+	// base url. Tunnels that hand over the connections themselves return a
+	// listener to serve, others forward to local.
+	open(ctx context.Context, local *url.URL) (*url.URL, net.Listener, error)
+	String() string
+}
+
+// newTunnel returns the tunnel for -tunnel, an ssh:// url or the name or
+// path of the cloudflared or ngrok binary.
+func newTunnel(spec string) (tunnel, error) {
+	if u, err := url.Parse(spec); err == nil && u.Scheme == "ssh" {
+		if u.Hostname() == "" {
+			return nil, fmt.Errorf("no host in %q", spec)
+		}
+		return &sshTunnel{server: u}, nil
+	}
+	switch strings.TrimSuffix(filepath.Base(spec), ".exe") {
+	case "cloudflared":
+		return &commandTunnel{bin: spec, args: cloudflaredArgs, find: cloudflaredURL}, nil
+	case "ngrok":
+		return &commandTunnel{bin: spec, args: ngrokArgs, find: ngrokURL}, nil
+	}
+	return nil, fmt.Errorf("unknown tunnel %q, use ssh://user@host, cloudflared or ngrok", spec)
+}
+
+// sshTunnel asks an ssh server to listen on the port of the share and
+// passes the connections back, like ssh -R. The server needs GatewayPorts
+// enabled for the port to be reachable from outside.
+type sshTunnel struct {
+	server *url.URL
+}
+
+func (t *sshTunnel) String() string {
+	return "ssh " + t.server.Host
+}
+
+func (t *sshTunnel) open(ctx context.Context, local *url.URL) (*url.URL, net.Listener, error) {
+	user := t.server.User.Username()
+	if user == "" {
+		user = os.Getenv("USER")
+	}
+	config := &ssh.ClientConfig{User: user, Timeout: 10 * time.Second}
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			defer conn.Close()
+			config.Auth = append(config.Auth, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, nil, err
+	}
+	var signers []ssh.Signer
+	for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+		b, err := os.ReadFile(filepath.Join(home, ".ssh", name))
+		if err != nil {
+			continue
+		}
+		// Keys with a passphrase are left to the agent.
+		if signer, err := ssh.ParsePrivateKey(b); err == nil {
+			signers = append(signers, signer)
+		}
+	}
+	if len(signers) > 0 {
+		config.Auth = append(config.Auth, ssh.PublicKeys(signers...))
+	}
+	if config.HostKeyCallback, err = knownhosts.New(filepath.Join(home, ".ssh", "known_hosts")); err != nil {
+		return nil, nil, err
+	}
+	addr := t.server.Host
+	if t.server.Port() == "" {
+		addr = net.JoinHostPort(t.server.Hostname(), "22")
+	}
+	client, err := ssh.Dial("tcp", addr, config)
+	if err != nil {
+		return nil, nil, err
+	}
+	ln, err := client.Listen("tcp", net.JoinHostPort("0.0.0.0", local.Port()))
+	if err != nil {
+		client.Close()
+		return nil, nil, fmt.Errorf("remote listen: %w", err)
+	}
+	go func() {
+		// Keep idle connections from being dropped on the way.
+		tick := time.NewTicker(30 * time.Second)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				if _, _, err := client.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+					return
+				}
+			case <-ctx.Done():
+				client.Close()
+				return
+			}
+		}
+	}()
+	port := strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+	return &url.URL{Scheme: local.Scheme, Host: net.JoinHostPort(t.server.Hostname(), port)}, ln, nil
+}
+
+// commandTunnel runs a tunnel client, which forwards to the local address
+// and prints the public url among its output.
+type commandTunnel struct {
+	bin  string
+	args func(local *url.URL) []string
+	// find returns the public url if the line of output has it.
+	find func(line string) string
+}
+
+func (t *commandTunnel) String() string {
+	return filepath.Base(t.bin)
+}
+
+func (t *commandTunnel) open(ctx context.Context, local *url.URL) (*url.URL, net.Listener, error) {
+	cmd := exec.CommandContext(ctx, t.bin, t.args(local)...)
+	pr, pw := io.Pipe()
+	cmd.Stdout, cmd.Stderr = pw, pw
+	if err := cmd.Start(); err != nil {
+		return nil, nil, err
+	}
+	exited := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		pw.Close()
+		exited <- err
+	}()
+	found := make(chan string, 1)
+	go func() {
+		s := bufio.NewScanner(pr)
+		for s.Scan() {
+			if v := t.find(s.Text()); v != "" {
+				found <- v
+				break
+			}
+		}
+		// Keep reading, so the client does not block on its output.
+		io.Copy(io.Discard, pr)
+	}()
+	select {
+	case v := <-found:
+		u, err := url.Parse(v)
+		if err != nil {
+			return nil, nil, err
+		}
+		go func() {
+			if err := <-exited; ctx.Err() == nil {
+				log.Printf("%s exited: %v", t, err)
+			}
+		}()
+		return u, nil, nil
+	case err := <-exited:
+		if err == nil {
+			err = errors.New("exited")
+		}
+		return nil, nil, fmt.Errorf("%s: %w", t, err)
+	case <-time.After(30 * time.Second):
+		cmd.Process.Kill()
+		return nil, nil, fmt.Errorf("%s: no public url after 30s", t)
+	}
+}
+
+var cloudflaredPattern = regexp.MustCompile(`https://[a-z0-9-]+\.trycloudflare\.com`)
+
+func cloudflaredArgs(local *url.URL) []string {
+	args := []string{"tunnel", "--no-autoupdate", "--url", local.String()}
+	if local.Scheme == "https" {
+		args = append(args, "--no-tls-verify")
+	}
+	return args
+}
+
+func cloudflaredURL(line string) string {
+	return cloudflaredPattern.FindString(line)
+}
+
+func ngrokArgs(local *url.URL) []string {
+	return []string{"http", "--log", "stdout", "--log-format", "json", local.String()}
+}
+
+func ngrokURL(line string) string {
+	var entry struct {
+		Msg string `json:"msg"`
+		URL string `json:"url"`
+	}
+	if json.Unmarshal([]byte(line), &entry) != nil || entry.Msg != "started tunnel" {
+		return ""
+	}
+	return entry.URL
+}
+
+// tunnelTarget returns the url of the first tcp listener for tunnel clients
+// on this host.
+func tunnelTarget(scheme string, lns []net.Listener) *url.URL {
+	for _, ln := range lns {
+		addr, ok := ln.Addr().(*net.TCPAddr)
+		if !ok {
+			continue
+		}
+		// Listeners on all interfaces usually take ipv4 connections too.
+		ip := addr.IP
+		if ip.IsUnspecified() {
+			ip = net.IPv4(127, 0, 0, 1)
+		}
+		return &url.URL{Scheme: scheme, Host: net.JoinHostPort(ip.String(), strconv.Itoa(addr.Port))}
+	}
+	return nil
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+//go:embed assets/upload.html
+var uploadPage string
+
+var uploadForm = template.Must(template.New("upload").Parse(uploadPage))
+
+// uploadsOff turns the http uploads away while set, so webshare ctl can
+// switch them on and off.
+var uploadsOff atomic.Bool
+
+// localPath turns a slash separated request path into a filesystem path
+// below dir. Dot-dot elements cannot climb above dir.
+func localPath(dir, p string) string {
+	return filepath.Join(dir, filepath.FromSlash(path.Clean("/"+p)))
+}
+
+// resolvePath is like localPath, but rejects paths that refer to dir itself,
+// which cannot be the target of an upload, and paths the policy denies.
+func resolvePath(dir, p string) (string, error) {
+	if path.Clean("/"+p) == "/" {
+		return "", errors.New("empty path")
+	}
+	if policy.denied(p) || !policy.followed(dir, p) {
+		return "", errors.New("access denied")
+	}
+	return localPath(dir, p), nil
+}
+
+// uniquePath returns fn, or a variant like "name (1).ext" if fn already exists.
+func uniquePath(fn string) string {
+	if _, err := os.Stat(fn); os.IsNotExist(err) {
+		return fn
+	}
+	ext := filepath.Ext(fn)
+	base := strings.TrimSuffix(fn, ext)
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s (%d)%s", base, i, ext)
+		if _, err := os.Stat(candidate); os.IsNotExist(err) {
+			return candidate
+		}
+	}
+}
+
+// writeFile copies r to fn through a temporary file in the same directory,
+// so an interrupted upload never leaves a truncated file behind.
+func writeFile(fn string, r io.Reader) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+		return 0, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(fn), ".webshare-upload-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	n, err := io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		return n, err
+	}
+	if err := tmp.Close(); err != nil {
+		return n, err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return n, err
+	}
+	return n, os.Rename(tmp.Name(), fn)
+}
+
+// uploadHandler serves the upload form on GET and stores files sent via
+// POST, either as a multipart form or as a raw body with a name parameter.
+func uploadHandler(dir string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if uploadsOff.Load() {
+			http.Error(w, "uploads are turned off", http.StatusForbidden)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			uploadForm.Execute(w, nil)
+		case http.MethodPost:
+			saved, err := saveUpload(dir, r)
+			if err != nil {
+				logRequest(r, "upload failed: %v", err)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if strings.Contains(r.Header.Get("Accept"), "text/html") {
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				uploadForm.Execute(w, saved)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			for _, name := range saved {
+				fmt.Fprintln(w, name)
+			}
+		default:
+			w.Header().Set("Allow", "GET, HEAD, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// receiveHandler serves only the upload form of dir, at the root and at
+// /upload, for webshare receive.
+func receiveHandler(dir string) http.Handler {
+	up := uploadHandler(dir)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" && r.URL.Path != "/upload" {
+			http.NotFound(w, r)
+			return
+		}
+		up.ServeHTTP(w, r)
+	})
+}
+
+// saveUpload stores the files from a POST request in dir and returns their
+// names relative to dir. Existing files are never overwritten.
+func saveUpload(dir string, r *http.Request) ([]string, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			return nil, errors.New("raw upload requires a name parameter")
+		}
+		fn, err := resolvePath(dir, name)
+		if err != nil {
+			return nil, err
+		}
+		fn = uniquePath(fn)
+		n, err := writeFile(fn, r.Body)
+		if err != nil {
+			return nil, err
+		}
+		logRequest(r, "uploaded %s [%d]", fn, n)
+		emitUpload(r, filepath.Base(fn), n)
+		return []string{filepath.Base(fn)}, nil
+	}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	var saved []string
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return saved, err
+		}
+		if part.FileName() == "" {
+			continue
+		}
+		// Only keep the last path element; browsers may send full paths.
+		name := filepath.Base(filepath.FromSlash(strings.ReplaceAll(part.FileName(), `\`, "/")))
+		fn, err := resolvePath(dir, name)
+		if err != nil {
+			return saved, err
+		}
+		fn = uniquePath(fn)
+		n, err := writeFile(fn, part)
+		part.Close()
+		if err != nil {
+			return saved, err
+		}
+		logRequest(r, "uploaded %s [%d]", fn, n)
+		emitUpload(r, filepath.Base(fn), n)
+		saved = append(saved, filepath.Base(fn))
+	}
+	if len(saved) == 0 {
+		return nil, errors.New("no files in upload")
+	}
+	return saved, nil
+}
+
+// putHandler wraps h and additionally accepts PUT requests, writing the raw
+// request body to the requested path, replacing any existing file.
+func putHandler(dir string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if uploadsOff.Load() {
+			http.Error(w, "uploads are turned off", http.StatusForbidden)
+			return
+		}
+		fn, err := resolvePath(dir, r.URL.Path)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if fi, err := os.Stat(fn); err == nil && fi.IsDir() {
+			http.Error(w, "cannot overwrite a directory", http.StatusConflict)
+			return
+		}
+		n, err := writeFile(fn, r.Body)
+		if err != nil {
+			logRequest(r, "upload failed: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		logRequest(r, "uploaded %s [%d]", fn, n)
+		emitUpload(r, r.URL.Path, n)
+		w.WriteHeader(http.StatusCreated)
+	})
+}
+
+// emitUpload reports the upload of n bytes to name.
+func emitUpload(r *http.Request, name string, n int64) {
+	emit(event{
+		Event:  "uploaded",
+		Remote: remoteHost(r),
+		Path:   name,
+		Bytes:  n,
+		Text:   fmt.Sprintf("%s uploaded %s (%s)", remoteHost(r), name, formatSize(n)),
+	})
+}
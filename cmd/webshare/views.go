@@ -0,0 +1,80 @@
+package main
+
+import (
+	_ "embed"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+//go:embed assets/player.html
+var playerPage string
+
+//go:embed assets/pdf.html
+var pdfPage string
+
+// viewTemplates holds the viewer pages by the name used in ?view=.
+var viewTemplates = map[string]*template.Template{
+	"player": template.Must(template.New("player").Parse(playerPage)),
+	"pdf":    template.Must(template.New("pdf").Parse(pdfPage)),
+}
+
+// mediaKind returns "video" or "audio" for files browsers can usually play
+// inline, and an empty string otherwise.
+func mediaKind(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".mp4", ".m4v", ".webm", ".ogv", ".mov":
+		return "video"
+	case ".mp3", ".m4a", ".aac", ".ogg", ".oga", ".opus", ".flac", ".wav":
+		return "audio"
+	}
+	return ""
+}
+
+// fileView returns the name of the viewer page for a file, to be requested
+// with ?view=, or an empty string if there is none.
+func fileView(name string) string {
+	if mediaKind(name) != "" {
+		return "player"
+	}
+	if strings.EqualFold(filepath.Ext(name), ".pdf") {
+		return "pdf"
+	}
+	return ""
+}
+
+// viewData is passed to the viewer page templates.
+type viewData struct {
+	Name string
+	// URL is the relative link to the file itself.
+	URL  string
+	Kind string
+}
+
+// viewHandler wraps h and renders viewer pages for files requested with a
+// view query parameter, if stat finds them. The file itself is still served
+// by h.
+func viewHandler(stat func(name string) (fs.FileInfo, error), h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		view := r.URL.Query().Get("view")
+		name := path.Base(r.URL.Path)
+		if view == "" || view != fileView(name) || strings.HasSuffix(r.URL.Path, "/") {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if fi, err := stat(r.URL.Path); err != nil || fi.IsDir() {
+			http.NotFound(w, r)
+			return
+		}
+		u := url.URL{Path: name}
+		data := viewData{Name: name, URL: u.String(), Kind: mediaKind(name)}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := viewTemplates[view].Execute(w, data); err != nil {
+			logRequest(r, "%s template: %v", view, err)
+		}
+	})
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+
+	"golang.org/x/net/webdav"
+)
+
+// webdavHandler wraps h and answers WebDAV requests for dir, so the share can
+// be mounted by file managers. Plain GET, HEAD and POST requests still go to
+// h, which keeps the browser listing working on the same URLs. Unless
+// writable is set and uploads are not turned off, only the read-only
+// WebDAV methods are allowed.
+func webdavHandler(dir string, writable bool, h http.Handler) http.Handler {
+	dav := &webdav.Handler{
+		FileSystem: policyFS{FileSystem: webdav.Dir(dir), dir: dir},
+		LockSystem: webdav.NewMemLS(),
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				logRequest(r, "webdav %s %s: %v", r.Method, r.URL.Path, err)
+			}
+		},
+	}
+	// Below a mount, h sees paths without the prefix, but WebDAV needs the
+	// full paths for its responses.
+	serveDAV := func(w http.ResponseWriter, r *http.Request) {
+		prefix := mountPrefix(r)
+		if prefix == "" {
+			dav.ServeHTTP(w, r)
+			return
+		}
+		u := *r.URL
+		u.Path, u.RawPath = prefix+u.Path, ""
+		r2 := *r
+		r2.URL = &u
+		d := *dav
+		d.Prefix = prefix
+		d.ServeHTTP(w, &r2)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodPost:
+			h.ServeHTTP(w, r)
+		case http.MethodOptions, "PROPFIND":
+			serveDAV(w, r)
+		default:
+			if !writable {
+				http.Error(w, "read-only share", http.StatusForbidden)
+				return
+			}
+			if uploadsOff.Load() {
+				http.Error(w, "uploads are turned off", http.StatusForbidden)
+				return
+			}
+			serveDAV(w, r)
+		}
+	})
+}
+
+// policyFS applies the path policy to a WebDAV file system: denied paths do
+// not exist and unlisted entries are left out of directory listings.
+type policyFS struct {
+	webdav.FileSystem
+	dir string
+}
+
+func (p policyFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if policy.denied(name) || !policy.followed(p.dir, name) {
+		return os.ErrPermission
+	}
+	return p.FileSystem.Mkdir(ctx, name, perm)
+}
+
+func (p policyFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if policy.denied(name) || !policy.followed(p.dir, name) {
+		return nil, os.ErrNotExist
+	}
+	f, err := p.FileSystem.OpenFile(ctx, name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	if fi, err := f.Stat(); err == nil && !policy.allowed(name, fi.IsDir()) {
+		f.Close()
+		return nil, os.ErrNotExist
+	}
+	return policyFile{File: f, dir: p.dir, name: name}, nil
+}
+
+func (p policyFS) RemoveAll(ctx context.Context, name string) error {
+	if _, err := p.Stat(ctx, name); err != nil {
+		return err
+	}
+	return p.FileSystem.RemoveAll(ctx, name)
+}
+
+func (p policyFS) Rename(ctx context.Context, oldName, newName string) error {
+	if _, err := p.Stat(ctx, oldName); err != nil {
+		return err
+	}
+	if policy.denied(newName) || !policy.followed(p.dir, newName) {
+		return os.ErrPermission
+	}
+	return p.FileSystem.Rename(ctx, oldName, newName)
+}
+
+func (p policyFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	return policy.stat(p.dir, name)
+}
+
+type policyFile struct {
+	webdav.File
+	dir, name string
+}
+
+func (f policyFile) Readdir(count int) ([]fs.FileInfo, error) {
+	infos, err := f.File.Readdir(count)
+	kept := infos[:0]
+	for _, fi := range infos {
+		if policy.listedEntry(f.dir, path.Join(f.name, fi.Name()), fs.FileInfoToDirEntry(fi)) {
+			kept = append(kept, fi)
+		}
+	}
+	return kept, err
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// webhook posts events as JSON to a url, e.g. a Slack, Matrix or ntfy hook,
+// in the background so requests do not wait for it.
+type webhook struct {
+	url    string
+	client http.Client
+	queue  chan event
+	done   chan struct{}
+}
+
+func newWebhook(url string) *webhook {
+	wh := &webhook{
+		url:    url,
+		client: http.Client{Timeout: 10 * time.Second},
+		queue:  make(chan event, 64),
+		done:   make(chan struct{}),
+	}
+	go wh.run()
+	return wh
+}
+
+// send queues e, or drops it if the hook cannot keep up.
+func (wh *webhook) send(e event) {
+	select {
+	case wh.queue <- e:
+	default:
+		log.Printf("webhook: dropped %s event, too many queued", e.Event)
+	}
+}
+
+func (wh *webhook) run() {
+	defer close(wh.done)
+	for e := range wh.queue {
+		if err := wh.post(e); err != nil {
+			log.Printf("webhook: %s event: %v", e.Event, err)
+		}
+	}
+}
+
+func (wh *webhook) post(e event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	resp, err := wh.client.Post(wh.url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s: %s", wh.url, resp.Status)
+	}
+	return nil
+}
+
+// close sends the queued events, waiting at most timeout.
+func (wh *webhook) close(timeout time.Duration) {
+	close(wh.queue)
+	select {
+	case <-wh.done:
+	case <-time.After(timeout):
+		log.Printf("webhook: gave up on %d events", len(wh.queue))
+	}
+}
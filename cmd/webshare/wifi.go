@@ -0,0 +1,17 @@
+package main
+
+import "strings"
+
+// wifiEscaper escapes the special characters of the fields of a WIFI: uri.
+var wifiEscaper = strings.NewReplacer(`\`, `\\`, `;`, `\;`, `,`, `\,`, `:`, `\:`, `"`, `\"`)
+
+// wifiURI returns the text of a qr code to join the network ssid, which
+// phone cameras offer to connect to. An empty password is for an open
+// network.
+func wifiURI(ssid, password string) string {
+	auth := "WPA"
+	if password == "" {
+		auth = "nopass"
+	}
+	return "WIFI:T:" + auth + ";S:" + wifiEscaper.Replace(ssid) + ";P:" + wifiEscaper.Replace(password) + ";;"
+}